@@ -0,0 +1,322 @@
+// Package agent runs a worker agent: a process, usually on another machine,
+// that leases runs from the scheduler over HTTP, executes them and reports
+// their output and result back.
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"jobScheduler/config"
+	"jobScheduler/logger"
+	"jobScheduler/models"
+	"jobScheduler/worker"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// leaseWait is how long a lease request waits on the server for a run.
+	leaseWait = 30 * time.Second
+	// requestTimeout limits every other request to the server.
+	requestTimeout = 30 * time.Second
+	// retryDelay is how long to wait after a failed request before trying
+	// again.
+	retryDelay = 5 * time.Second
+	// resultAttempts is how often the result of a run is sent before it is
+	// given up on; the server abandons the run once its lease expires.
+	resultAttempts = 5
+
+	// Output is sent every outputFlushInterval, or sooner once
+	// outputFlushSize bytes are waiting.
+	outputFlushInterval = 250 * time.Millisecond
+	outputFlushSize     = 64 << 10
+)
+
+// errUnknownAgent is returned for requests the server answered with 404
+// because it does not know the agent; the agent registers again.
+var errUnknownAgent = errors.New("agent is not registered")
+
+// errConflict is returned for requests the server answered with 409: the
+// run is no longer leased by the agent, or is not to be executed.
+var errConflict = errors.New("run is no longer held by this agent")
+
+type agent struct {
+	cfg    *config.AgentConfig
+	client *http.Client
+
+	mu      sync.Mutex
+	id      uint
+	running map[uint]context.CancelFunc // by execution ID
+}
+
+// Run registers the agent and executes runs until ctx is cancelled. It then
+// stops leasing runs and returns once the running ones have finished.
+func Run(ctx context.Context, cfg *config.AgentConfig) error {
+	a := &agent{
+		cfg:     cfg,
+		client:  &http.Client{},
+		running: make(map[uint]context.CancelFunc),
+	}
+	if err := a.register(ctx); err != nil {
+		return err
+	}
+
+	// Heartbeats go on until the last run has finished, so that the server
+	// keeps the leases of runs that outlive ctx.
+	heartbeatCtx, stopHeartbeat := context.WithCancel(context.Background())
+	defer stopHeartbeat()
+	go a.heartbeat(heartbeatCtx)
+
+	var wg sync.WaitGroup
+	for i := 1; i <= cfg.Workers; i++ {
+		wg.Add(1)
+		go func(workerID int) {
+			defer wg.Done()
+			a.work(ctx, workerID)
+		}(i)
+	}
+	wg.Wait()
+	logger.L.Info("Agent stopped", "agent_id", a.agentID(), "name", cfg.Name)
+	return nil
+}
+
+func (a *agent) agentID() uint {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.id
+}
+
+// register registers the agent, retrying until it succeeds or ctx is
+// cancelled.
+func (a *agent) register(ctx context.Context) error {
+	hostname, _ := os.Hostname()
+	request := models.Agent{
+		Name:     a.cfg.Name,
+		Hostname: hostname,
+		Labels:   a.cfg.Labels,
+		Queues:   a.cfg.Queues,
+		Workers:  a.cfg.Workers,
+	}
+	for {
+		var registered models.Agent
+		status, err := a.do(ctx, http.MethodPost, "/api/agent/register", request, &registered, requestTimeout)
+		if err == nil {
+			a.mu.Lock()
+			a.id = registered.ID
+			a.mu.Unlock()
+			logger.L.Info("Agent registered", "agent_id", registered.ID, "name", a.cfg.Name, "server", a.cfg.ServerURL, "queues", registered.Queues, "labels", registered.Labels)
+			return nil
+		}
+		if status == http.StatusBadRequest || status == http.StatusUnauthorized {
+			// Retrying will not help.
+			return fmt.Errorf("failed to register agent: %w", err)
+		}
+		logger.L.Error("Failed to register agent", "server", a.cfg.ServerURL, "error", err)
+		if !sleep(ctx, retryDelay) {
+			return ctx.Err()
+		}
+	}
+}
+
+// heartbeat sends a heartbeat every worker.AgentHeartbeatInterval and
+// cancels the runs the server says were cancelled.
+func (a *agent) heartbeat(ctx context.Context) {
+	ticker := time.NewTicker(worker.AgentHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var response struct {
+			Cancel []uint `json:"cancel"`
+		}
+		_, err := a.do(ctx, http.MethodPost, a.path("/heartbeat"), nil, &response, requestTimeout)
+		if errors.Is(err, errUnknownAgent) {
+			logger.L.Warn("Server no longer knows the agent, registering again", "agent_id", a.agentID())
+			if err := a.register(ctx); err != nil {
+				logger.L.Error("Failed to register agent again", "error", err)
+			}
+			continue
+		}
+		if err != nil {
+			logger.L.Error("Failed to send heartbeat", "agent_id", a.agentID(), "error", err)
+			continue
+		}
+		for _, executionID := range response.Cancel {
+			a.cancel(executionID)
+		}
+	}
+}
+
+// work leases and executes runs one after another as slot workerID of the
+// agent until ctx is cancelled.
+func (a *agent) work(ctx context.Context, workerID int) {
+	for ctx.Err() == nil {
+		var item models.QueueItem
+		status, err := a.do(ctx, http.MethodGet, a.path("/lease?wait="+leaseWait.String()), nil, &item, leaseWait+10*time.Second)
+		if err != nil {
+			if ctx.Err() == nil && !errors.Is(err, errUnknownAgent) {
+				logger.L.Error("Failed to lease a run", "agent_id", a.agentID(), "worker_id", workerID, "error", err)
+			}
+			sleep(ctx, retryDelay)
+			continue
+		}
+		if status == http.StatusNoContent {
+			continue
+		}
+		a.execute(workerID, item)
+	}
+}
+
+// execute starts and executes a leased run. It runs to completion even if
+// the agent is shutting down.
+func (a *agent) execute(workerID int, item models.QueueItem) {
+	var assignment worker.AgentAssignment
+	start := struct {
+		WorkerID int `json:"workerId"`
+	}{workerID}
+	_, err := a.do(context.Background(), http.MethodPost, a.runPath(item.ExecutionID, "start"), start, &assignment, requestTimeout)
+	if errors.Is(err, errConflict) {
+		logger.L.Info("Skipping run that is not to be executed", "execution_id", item.ExecutionID)
+		return
+	}
+	if err != nil {
+		// The lease expires and the server re-queues the run.
+		logger.L.Error("Failed to start run", "execution_id", item.ExecutionID, "error", err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a.mu.Lock()
+	a.running[assignment.ExecutionID] = cancel
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		delete(a.running, assignment.ExecutionID)
+		a.mu.Unlock()
+	}()
+
+	logger.L.Info("Executing run", "job_id", assignment.Job.ID, "execution_id", assignment.ExecutionID, "worker_id", workerID)
+	output := a.newUploader(assignment.ExecutionID, cancel)
+	go output.run()
+	result := worker.ExecuteAssignment(ctx, assignment, output.stream(worker.StreamStdout), output.stream(worker.StreamStderr), nil)
+	output.close()
+
+	for attempt := 1; attempt <= resultAttempts; attempt++ {
+		_, err = a.do(context.Background(), http.MethodPost, a.runPath(assignment.ExecutionID, "result"), result, nil, requestTimeout)
+		if err == nil || errors.Is(err, errConflict) {
+			break
+		}
+		logger.L.Error("Failed to send run result", "execution_id", assignment.ExecutionID, "attempt", attempt, "error", err)
+		time.Sleep(retryDelay)
+	}
+	if err != nil {
+		logger.L.Error("Gave up on sending run result", "execution_id", assignment.ExecutionID, "error", err)
+		return
+	}
+	logger.L.Info("Run finished", "job_id", assignment.Job.ID, "execution_id", assignment.ExecutionID, "status", result.Status)
+}
+
+// cancel cancels a running run.
+func (a *agent) cancel(executionID uint) {
+	a.mu.Lock()
+	cancel, ok := a.running[executionID]
+	a.mu.Unlock()
+	if ok {
+		logger.L.Info("Cancelling run", "execution_id", executionID)
+		cancel()
+	}
+}
+
+func (a *agent) path(suffix string) string {
+	return fmt.Sprintf("/api/agent/%d%s", a.agentID(), suffix)
+}
+
+func (a *agent) runPath(executionID uint, action string) string {
+	return a.path(fmt.Sprintf("/executions/%d/%s", executionID, action))
+}
+
+// do sends a request with body encoded as JSON and decodes the "data" of
+// the response into out. It returns the status code of the response.
+func (a *agent) do(ctx context.Context, method, path string, body, out any, timeout time.Duration) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, a.cfg.ServerURL+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+a.cfg.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent {
+		return resp.StatusCode, nil
+	}
+	var envelope struct {
+		Error string          `json:"error"`
+		Data  json.RawMessage `json:"data"`
+	}
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if err := json.Unmarshal(raw, &envelope); err != nil {
+		return resp.StatusCode, fmt.Errorf("unexpected response %s: %s", resp.Status, bytes.TrimSpace(raw))
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound && path != "/api/agent/register":
+		return resp.StatusCode, errUnknownAgent
+	case resp.StatusCode == http.StatusConflict:
+		return resp.StatusCode, fmt.Errorf("%w: %s", errConflict, envelope.Error)
+	case resp.StatusCode >= 300:
+		return resp.StatusCode, fmt.Errorf("%s: %s", resp.Status, envelope.Error)
+	}
+
+	if out == nil {
+		return resp.StatusCode, nil
+	}
+	if len(envelope.Data) > 0 {
+		return resp.StatusCode, json.Unmarshal(envelope.Data, out)
+	}
+	// Responses without "data" carry their fields at the top level.
+	return resp.StatusCode, json.Unmarshal(raw, out)
+}
+
+// sleep waits for d and reports whether ctx is still live.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,267 @@
+package agent
+
+import (
+	"context"
+	"jobScheduler/config"
+	"jobScheduler/handlers"
+	"jobScheduler/models"
+	"jobScheduler/routes"
+	"jobScheduler/worker"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const testToken = "agent-test-token"
+
+// testAgents are started against the test server, with two workers each.
+var testAgents = []string{"agent-a", "agent-b"}
+
+// testServer is the scheduler instance the test agents talk to. Since
+// StartWorkerPool sets up process-wide state, it is started only once, and
+// so are the agents: an agent that stopped could still be handed a run by a
+// lease request it left waiting on the server.
+var testServer struct {
+	once sync.Once
+	dir  string
+	db   *gorm.DB
+	err  error
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if testServer.dir != "" {
+		os.RemoveAll(testServer.dir)
+	}
+	os.Exit(code)
+}
+
+// startServer starts the agent API of a scheduler instance whose own
+// workers take no runs, so that every run goes to one of the test agents,
+// and waits for the agents to register.
+func startServer(t *testing.T) *gorm.DB {
+	t.Helper()
+	testServer.once.Do(func() {
+		var url string
+		testServer.db, url, testServer.err = newServer()
+		if testServer.err != nil {
+			return
+		}
+		for _, name := range testAgents {
+			cfg := &config.AgentConfig{ServerURL: url, Token: testToken, Name: name, Queues: []string{models.DefaultQueue}, Workers: 2}
+			go Run(context.Background(), cfg)
+		}
+	})
+	if testServer.err != nil {
+		t.Fatal(testServer.err)
+	}
+
+	// Runs nobody could take would be recorded as unschedulable.
+	waitFor(t, 5*time.Second, "the agents to register", func() bool {
+		var count int64
+		testServer.db.Model(&models.Agent{}).Where("name IN ?", testAgents).Count(&count)
+		return int(count) == len(testAgents)
+	})
+	return testServer.db
+}
+
+func newServer() (*gorm.DB, string, error) {
+	dir, err := os.MkdirTemp("", "agent-test")
+	if err != nil {
+		return nil, "", err
+	}
+	testServer.dir = dir
+	db, err := gorm.Open(sqlite.Open(filepath.Join(dir, "dispatch.db")+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate"), &gorm.Config{})
+	if err != nil {
+		return nil, "", err
+	}
+	err = db.AutoMigrate(&models.Job{}, &models.User{}, &models.JobExecution{}, &models.Secret{}, &models.QueueItem{}, &models.Agent{}, &models.LeaderLease{}, &models.Instance{})
+	if err != nil {
+		return nil, "", err
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, "", err
+	}
+	url := "http://" + ln.Addr().String()
+	worker.StartWorkerPool(&config.WorkerConfig{
+		Queues:      []config.QueueConfig{{Name: models.DefaultQueue, Workers: 0, Capacity: 100}},
+		OutputLimit: 64 << 10,
+		LogDir:      filepath.Join(dir, "logs"),
+		AgentToken:  testToken,
+		InstanceURL: url,
+	}, db)
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	api := app.Group("/api")
+	agentAuth := handlers.AgentAuthRequired(testToken)
+	api.Post("/agent/register", agentAuth, routes.RegisterAgent(db))
+	api.Post("/agent/:id/heartbeat", agentAuth, routes.AgentHeartbeat(db))
+	api.Get("/agent/:id/lease", agentAuth, routes.LeaseRun(db))
+	api.Post("/agent/:id/executions/:executionId/start", agentAuth, routes.StartAgentRun(db))
+	api.Post("/agent/:id/executions/:executionId/output", agentAuth, routes.AgentOutput(db))
+	api.Post("/agent/:id/executions/:executionId/result", agentAuth, routes.AgentResult(db))
+	go app.Listener(ln)
+	return db, url, nil
+}
+
+func createJob(t *testing.T, db *gorm.DB, name, command string) models.Job {
+	t.Helper()
+	job := models.Job{Name: name, Command: command, Cron: "0 0 1 1 *", Type: models.JobTypeCommand}
+	if err := db.Create(&job).Error; err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func enqueue(t *testing.T, db *gorm.DB, job models.Job) models.JobExecution {
+	t.Helper()
+	execution, err := worker.Enqueue(db, worker.Run{Job: job, Attempt: 1, Trigger: models.TriggerManual})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return execution
+}
+
+// waitFor polls until done returns true, failing the test after timeout.
+func waitFor(t *testing.T, timeout time.Duration, what string, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func finished(db *gorm.DB, ids []uint) func() bool {
+	return func() bool {
+		var count int64
+		db.Model(&models.JobExecution{}).
+			Where("id IN ? AND status NOT IN ?", ids, []string{models.ExecutionStatusQueued, models.ExecutionStatusRunning}).
+			Count(&count)
+		return int(count) == len(ids)
+	}
+}
+
+// TestAgents runs a server with two agents of two workers each and checks
+// that every run is leased by exactly one of them, that their results and
+// heartbeats are handled, and that a cancellation reaches the agent.
+func TestAgents(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for agent heartbeats")
+	}
+	db := startServer(t)
+
+	marks := filepath.Join(t.TempDir(), "runs")
+	job := createJob(t, db, "echo", "echo started >> "+marks+"; sleep 0.5; echo hello; echo oops >&2")
+	failing := createJob(t, db, "fail", "echo failing; exit 3")
+
+	var ids []uint
+	for i := 0; i < 8; i++ {
+		ids = append(ids, enqueue(t, db, job).ID)
+	}
+	failed := enqueue(t, db, failing)
+	waitFor(t, 30*time.Second, "the runs to finish", finished(db, append(ids, failed.ID)))
+
+	t.Run("each run is executed once", func(t *testing.T) {
+		data, err := os.ReadFile(marks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Count(string(data), "started"); got != len(ids) {
+			t.Errorf("the command ran %d times for %d runs", got, len(ids))
+		}
+
+		var items []models.QueueItem
+		db.Where("execution_id IN ?", ids).Find(&items)
+		holders := make(map[string]bool)
+		for _, item := range items {
+			if item.State != models.QueueStateDone || item.Deliveries != 1 {
+				t.Errorf("item of execution %d is %s after %d deliveries, want done after 1", item.ExecutionID, item.State, item.Deliveries)
+			}
+			if !strings.HasPrefix(item.LeasedBy, "agent:") {
+				t.Errorf("item of execution %d was leased by %q, want an agent", item.ExecutionID, item.LeasedBy)
+			}
+			holders[item.LeasedBy] = true
+		}
+		if len(holders) != 2 {
+			t.Errorf("runs were leased by %v, want both agents", holders)
+		}
+	})
+
+	t.Run("results are recorded", func(t *testing.T) {
+		var executions []models.JobExecution
+		db.Where("id IN ?", ids).Find(&executions)
+		for _, execution := range executions {
+			if execution.Status != models.ExecutionStatusSucceeded {
+				t.Errorf("execution %d is %s, want succeeded", execution.ID, execution.Status)
+			}
+			if !slices.Contains(testAgents, execution.Agent) {
+				t.Errorf("execution %d ran on agent %q", execution.ID, execution.Agent)
+			}
+			if execution.ExitCode == nil || *execution.ExitCode != 0 {
+				t.Errorf("execution %d has exit code %v, want 0", execution.ID, execution.ExitCode)
+			}
+			if execution.Stdout != "hello\n" || execution.Stderr != "oops\n" {
+				t.Errorf("execution %d has stdout %q and stderr %q", execution.ID, execution.Stdout, execution.Stderr)
+			}
+		}
+
+		var execution models.JobExecution
+		db.First(&execution, failed.ID)
+		if execution.Status != models.ExecutionStatusFailed || execution.ExitCode == nil || *execution.ExitCode != 3 {
+			t.Errorf("failing execution is %s with exit code %v, want failed with 3", execution.Status, execution.ExitCode)
+		}
+		if execution.Output != "failing\n" {
+			t.Errorf("failing execution has output %q", execution.Output)
+		}
+	})
+
+	t.Run("heartbeats renew leases and deliver cancellations", func(t *testing.T) {
+		sleeper := createJob(t, db, "sleep", "echo waiting; sleep 30")
+		execution := enqueue(t, db, sleeper)
+		waitFor(t, 10*time.Second, "the run to start", func() bool {
+			db.First(&execution, execution.ID)
+			return execution.Status == models.ExecutionStatusRunning
+		})
+
+		var item models.QueueItem
+		db.Where("execution_id = ?", execution.ID).First(&item)
+		var agent models.Agent
+		db.Where("name = ?", execution.Agent).First(&agent)
+		waitFor(t, 2*worker.AgentHeartbeatInterval, "a heartbeat", func() bool {
+			var renewed models.Agent
+			db.First(&renewed, agent.ID)
+			return renewed.LastHeartbeatAt.After(agent.LastHeartbeatAt)
+		})
+		var renewed models.QueueItem
+		db.First(&renewed, item.ID)
+		if !renewed.LeaseExpiresAt.After(*item.LeaseExpiresAt) {
+			t.Errorf("the heartbeat did not renew the lease: it expires at %s, as before", renewed.LeaseExpiresAt)
+		}
+
+		if err := worker.CancelExecution(execution.ID, "tester"); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, 2*worker.AgentHeartbeatInterval, "the run to be cancelled", finished(db, []uint{execution.ID}))
+		db.First(&execution, execution.ID)
+		if execution.Status != models.ExecutionStatusCancelled || execution.CancelledBy != "tester" {
+			t.Errorf("execution is %s, cancelled by %q; want cancelled by tester", execution.Status, execution.CancelledBy)
+		}
+		if execution.Output != "waiting\n" {
+			t.Errorf("cancelled execution has output %q", execution.Output)
+		}
+	})
+}
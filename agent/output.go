@@ -0,0 +1,128 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"jobScheduler/logger"
+	"jobScheduler/worker"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// uploader sends the output of a run to the server in batches while the run
+// is executing. Output that cannot be sent is dropped rather than holding up
+// the run.
+type uploader struct {
+	a           *agent
+	executionID uint
+	cancelRun   context.CancelFunc
+
+	mu      sync.Mutex
+	pending []worker.OutputChunk
+	size    int
+
+	flush chan struct{}
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+func (a *agent) newUploader(executionID uint, cancelRun context.CancelFunc) *uploader {
+	u := &uploader{
+		a:           a,
+		executionID: executionID,
+		cancelRun:   cancelRun,
+		flush:       make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+	u.wg.Add(1)
+	return u
+}
+
+// stream returns a writer for one stream of the run.
+func (u *uploader) stream(name string) *outputWriter {
+	return &outputWriter{u, name}
+}
+
+type outputWriter struct {
+	u      *uploader
+	stream string
+}
+
+func (w *outputWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	u := w.u
+	u.mu.Lock()
+	if n := len(u.pending); n > 0 && u.pending[n-1].Stream == w.stream {
+		u.pending[n-1].Data = append(u.pending[n-1].Data, p...)
+	} else {
+		u.pending = append(u.pending, worker.OutputChunk{Stream: w.stream, Data: append([]byte(nil), p...)})
+	}
+	u.size += len(p)
+	full := u.size >= outputFlushSize
+	u.mu.Unlock()
+
+	if full {
+		select {
+		case u.flush <- struct{}{}:
+		default:
+		}
+	}
+	return len(p), nil
+}
+
+// run sends the waiting output every outputFlushInterval, or sooner if a lot
+// is waiting, until close is called.
+func (u *uploader) run() {
+	defer u.wg.Done()
+	ticker := time.NewTicker(outputFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-u.done:
+			u.send()
+			return
+		case <-ticker.C:
+		case <-u.flush:
+		}
+		u.send()
+	}
+}
+
+// close sends the remaining output and stops the uploader.
+func (u *uploader) close() {
+	close(u.done)
+	u.wg.Wait()
+}
+
+func (u *uploader) send() {
+	u.mu.Lock()
+	chunks := u.pending
+	u.pending = nil
+	u.size = 0
+	u.mu.Unlock()
+	if len(chunks) == 0 {
+		return
+	}
+
+	body := struct {
+		Chunks []worker.OutputChunk `json:"chunks"`
+	}{chunks}
+	var response struct {
+		Cancel bool `json:"cancel"`
+	}
+	_, err := u.a.do(context.Background(), http.MethodPost, u.a.runPath(u.executionID, "output"), body, &response, requestTimeout)
+	switch {
+	case errors.Is(err, errConflict):
+		// The server gave up on the run, e.g. because its lease expired.
+		logger.L.Warn("Run is no longer held by this agent, cancelling it", "execution_id", u.executionID, "error", err)
+		u.cancelRun()
+	case err != nil:
+		logger.L.Error("Failed to send run output", "execution_id", u.executionID, "error", err)
+	case response.Cancel:
+		logger.L.Info("Run was cancelled", "execution_id", u.executionID)
+		u.cancelRun()
+	}
+}
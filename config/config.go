@@ -1,11 +1,17 @@
 package config
 
 import (
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"flag"
 	"fmt"
 	"jobScheduler/logger"
+	"net"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -35,9 +41,61 @@ func GetAdminCredential() (AdminCredential, error) {
 	return adminCredential, nil
 }
 
+// GetListenAddr returns the address the server listens on, from
+// LISTEN_ADDR, by default 0.0.0.0:3000.
+func GetListenAddr() string {
+	if addr := os.Getenv("LISTEN_ADDR"); addr != "" {
+		return addr
+	}
+	return "0.0.0.0:3000"
+}
+
+// defaultInstanceURL is the URL of an instance listening on addr, by the
+// name of its host if it listens on every interface.
+func defaultInstanceURL(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "http://" + addr
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host, _ = os.Hostname()
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// QueueConfig describes a named job queue with workers of its own.
+type QueueConfig struct {
+	Name     string
+	Workers  int
+	Capacity int // how many runs may wait in the queue
+}
+
 type WorkerConfig struct {
+	// QueueSize is how many runs may wait in the default queue, and Workers
+	// how many of them run at once.
 	QueueSize int
 	Workers   int
+	// Queues lists every queue, the default one first.
+	Queues []QueueConfig
+	// JobTimeout limits how long a job may run when it sets no timeout of
+	// its own. Zero means no limit.
+	JobTimeout time.Duration
+	// OutputLimit is how many bytes of an execution's output are stored in
+	// the database, split between its head and tail.
+	OutputLimit int
+	// LogDir holds the full, gzipped output of every execution.
+	LogDir string
+	// LogRetention is how long execution logs are kept. Zero keeps them forever.
+	LogRetention time.Duration
+	// AgentToken is the shared token worker agents authenticate with. Agents
+	// are refused when it is empty.
+	AgentToken string
+	// Labels describe the workers of this process, e.g. {"db": "true"}. Jobs
+	// with a node selector only run on workers whose labels match it.
+	Labels map[string]string
+	// InstanceURL is where the other instances sharing the database reach
+	// this one, to forward requests about the runs it holds.
+	InstanceURL string
 }
 
 // NewWorkerConfig creates a new configuration object by reading from environment variables.
@@ -73,14 +131,244 @@ func NewWorkerConfig() (*WorkerConfig, error) {
 		}
 	}
 
+	// --- Get Default Job Timeout ---
+	timeoutStr := os.Getenv("JOB_TIMEOUT_SECONDS")
+	if timeoutStr == "" {
+		config.JobTimeout = time.Hour // Default value
+	} else {
+		seconds, err := strconv.Atoi(timeoutStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JOB_TIMEOUT_SECONDS value: must be an integer")
+		}
+		if seconds < 0 {
+			return nil, fmt.Errorf("JOB_TIMEOUT_SECONDS must not be negative")
+		}
+		config.JobTimeout = time.Duration(seconds) * time.Second
+	}
+
+	// --- Get Output Capture Limit ---
+	outputLimitStr := os.Getenv("OUTPUT_LIMIT_BYTES")
+	if outputLimitStr == "" {
+		config.OutputLimit = 64 << 10 // Default value
+	} else {
+		config.OutputLimit, err = strconv.Atoi(outputLimitStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OUTPUT_LIMIT_BYTES value: must be an integer")
+		}
+	}
+
+	// --- Get Execution Log Storage ---
+	config.LogDir = os.Getenv("EXECUTION_LOG_DIR")
+	if config.LogDir == "" {
+		config.LogDir = "logs" // Default value
+	}
+
+	retentionStr := os.Getenv("EXECUTION_LOG_RETENTION_DAYS")
+	if retentionStr == "" {
+		config.LogRetention = 30 * 24 * time.Hour // Default value
+	} else {
+		days, err := strconv.Atoi(retentionStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EXECUTION_LOG_RETENTION_DAYS value: must be an integer")
+		}
+		if days < 0 {
+			return nil, fmt.Errorf("EXECUTION_LOG_RETENTION_DAYS must not be negative")
+		}
+		config.LogRetention = time.Duration(days) * 24 * time.Hour
+	}
+
 	// Validate the values
-	if config.Workers <= 0 {
-		return nil, fmt.Errorf("WORKERS count must be positive")
+	if config.Workers < 0 {
+		return nil, fmt.Errorf("WORKERS count must not be negative")
 	}
 	if config.QueueSize <= 0 {
 		return nil, fmt.Errorf("QUEUE_SIZE must be positive")
 	}
+	if config.OutputLimit <= 0 {
+		return nil, fmt.Errorf("OUTPUT_LIMIT_BYTES must be positive")
+	}
+
+	// --- Get Agent Token ---
+	config.AgentToken = os.Getenv("AGENT_TOKEN")
+	if config.AgentToken == "" {
+		logger.L.Warn("AGENT_TOKEN is not set. Worker agents are disabled.")
+	}
+
+	// --- Get Worker Labels ---
+	config.Labels, err = parseLabels(os.Getenv("WORKER_LABELS"), "WORKER_LABELS")
+	if err != nil {
+		return nil, err
+	}
+
+	// --- Get Instance URL ---
+	config.InstanceURL = strings.TrimRight(os.Getenv("INSTANCE_URL"), "/")
+	if config.InstanceURL == "" {
+		config.InstanceURL = defaultInstanceURL(GetListenAddr())
+	}
+
+	// --- Get Named Queues ---
+	config.Queues = []QueueConfig{{Name: "default", Workers: config.Workers, Capacity: config.QueueSize}}
+	queues, err := parseQueues(os.Getenv("QUEUES"))
+	if err != nil {
+		return nil, err
+	}
+	for _, queue := range queues {
+		for _, existing := range config.Queues {
+			if existing.Name == queue.Name {
+				return nil, fmt.Errorf("invalid QUEUES value: queue %q is configured twice; the default queue is set with WORKERS and QUEUE_SIZE", queue.Name)
+			}
+		}
+		config.Queues = append(config.Queues, queue)
+	}
 
 	// Return the populated config and a nil error on success
 	return config, nil
 }
+
+// parseQueues reads a comma-separated list of queues in the form
+// name:workers:capacity, e.g. "billing:2:50,cleanup:1:500". A queue without
+// workers is only served by agents.
+func parseQueues(value string) ([]QueueConfig, error) {
+	var queues []QueueConfig
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid QUEUES entry %q: must be name:workers:capacity", entry)
+		}
+		workers, err := strconv.Atoi(parts[1])
+		if err != nil || workers < 0 {
+			return nil, fmt.Errorf("invalid QUEUES entry %q: workers must be a non-negative integer", entry)
+		}
+		capacity, err := strconv.Atoi(parts[2])
+		if err != nil || capacity <= 0 {
+			return nil, fmt.Errorf("invalid QUEUES entry %q: capacity must be a positive integer", entry)
+		}
+		queues = append(queues, QueueConfig{Name: parts[0], Workers: workers, Capacity: capacity})
+	}
+	return queues, nil
+}
+
+type SecretsConfig struct {
+	// MasterKey is the 32-byte AES-256 key secrets are encrypted with. It is
+	// nil when SECRETS_MASTER_KEY is not set, which disables secrets.
+	MasterKey []byte
+}
+
+// NewSecretsConfig reads the secrets master key from SECRETS_MASTER_KEY,
+// given as 32 bytes encoded in base64 or hex.
+func NewSecretsConfig() (*SecretsConfig, error) {
+	config := &SecretsConfig{}
+
+	keyStr := os.Getenv("SECRETS_MASTER_KEY")
+	if keyStr == "" {
+		logger.L.Warn("SECRETS_MASTER_KEY is not set. Secrets are disabled.")
+		return config, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(keyStr)
+	if err != nil || len(key) != 32 {
+		key, err = hex.DecodeString(keyStr)
+	}
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("invalid SECRETS_MASTER_KEY value: must be 32 bytes encoded in base64 or hex")
+	}
+	config.MasterKey = key
+
+	return config, nil
+}
+
+// AgentConfig configures a process started as "worker agent". Every setting
+// can be given as a flag or through the environment variable named below.
+type AgentConfig struct {
+	ServerURL string            // AGENT_SERVER_URL, e.g. http://scheduler:3000
+	Token     string            // AGENT_TOKEN, as configured on the server
+	Name      string            // AGENT_NAME; defaults to hostname-pid
+	Labels    map[string]string // AGENT_LABELS, e.g. "gpu=true,region=eu"
+	Queues    []string          // AGENT_QUEUES, e.g. "default,billing"
+	Workers   int               // AGENT_WORKERS, runs executed at once
+}
+
+// NewAgentConfig reads the configuration of an agent from args, the
+// arguments after "worker agent", falling back to the environment.
+func NewAgentConfig(args []string) (*AgentConfig, error) {
+	if err := godotenv.Load(); err != nil {
+		logger.L.Warn("Could not load .env file, reading from OS environment. This is normal in production.")
+	}
+
+	hostname, _ := os.Hostname()
+	defaultName := fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	if name := os.Getenv("AGENT_NAME"); name != "" {
+		defaultName = name
+	}
+	defaultServer := os.Getenv("AGENT_SERVER_URL")
+	if defaultServer == "" {
+		defaultServer = "http://localhost:3000"
+	}
+	defaultQueues := os.Getenv("AGENT_QUEUES")
+	if defaultQueues == "" {
+		defaultQueues = "default"
+	}
+	defaultWorkers := 1
+	if workersStr := os.Getenv("AGENT_WORKERS"); workersStr != "" {
+		workers, err := strconv.Atoi(workersStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AGENT_WORKERS value: must be an integer")
+		}
+		defaultWorkers = workers
+	}
+
+	config := &AgentConfig{}
+	var labels, queues string
+	flags := flag.NewFlagSet("worker agent", flag.ContinueOnError)
+	flags.StringVar(&config.ServerURL, "server", defaultServer, "URL of the scheduler server")
+	flags.StringVar(&config.Token, "token", os.Getenv("AGENT_TOKEN"), "token the server accepts from agents")
+	flags.StringVar(&config.Name, "name", defaultName, "name of this agent")
+	flags.StringVar(&labels, "labels", os.Getenv("AGENT_LABELS"), "comma-separated key=value labels")
+	flags.StringVar(&queues, "queues", defaultQueues, "comma-separated queues to take runs from")
+	flags.IntVar(&config.Workers, "workers", defaultWorkers, "how many runs to execute at once")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	config.ServerURL = strings.TrimRight(config.ServerURL, "/")
+	if config.Token == "" {
+		return nil, errors.New("AGENT_TOKEN is not set")
+	}
+	if config.Workers <= 0 {
+		return nil, fmt.Errorf("AGENT_WORKERS must be positive")
+	}
+	for _, queue := range strings.Split(queues, ",") {
+		if queue = strings.TrimSpace(queue); queue != "" {
+			config.Queues = append(config.Queues, queue)
+		}
+	}
+	labelsConfig, err := parseLabels(labels, "AGENT_LABELS")
+	if err != nil {
+		return nil, err
+	}
+	config.Labels = labelsConfig
+
+	return config, nil
+}
+
+// parseLabels reads a comma-separated list of key=value labels from the
+// variable named name, e.g. "gpu=true,region=eu".
+func parseLabels(value, name string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, label := range strings.Split(value, ",") {
+		label = strings.TrimSpace(label)
+		if label == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(label, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("invalid %s entry %q: must be key=value", name, label)
+		}
+		labels[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return labels, nil
+}
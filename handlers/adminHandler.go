@@ -24,7 +24,7 @@ func SeedAdminUser(db *gorm.DB, adminCredential config.AdminCredential) {
 	// Hash the password from the environment variable
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(adminCredential.Password), bcrypt.DefaultCost)
 	if err != nil {
-		logger.L.Error("FATAL: Could not hash admin password for seeding: %v", err)
+		logger.L.Error("FATAL: Could not hash admin password for seeding", "error", err)
 		os.Exit(1)
 	}
 
@@ -37,7 +37,7 @@ func SeedAdminUser(db *gorm.DB, adminCredential config.AdminCredential) {
 
 	// Save the user to the database
 	if err := db.Create(&adminUser).Error; err != nil {
-		logger.L.Error("FATAL: Could not seed admin user: %v", err)
+		logger.L.Error("FATAL: Could not seed admin user", "error", err)
 		os.Exit(1)
 	}
 
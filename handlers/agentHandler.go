@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// AgentAuthRequired admits requests of worker agents, which authenticate
+// with "Authorization: Bearer <token>" using the server's AGENT_TOKEN.
+func AgentAuthRequired(token string) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		if token == "" {
+			return ctx.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+				"success": false,
+				"error":   "Worker agents are disabled: AGENT_TOKEN is not set",
+			})
+		}
+
+		given, ok := strings.CutPrefix(ctx.Get(fiber.HeaderAuthorization), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			return ctx.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"success": false,
+				"error":   "Unauthorized",
+			})
+		}
+		return ctx.Next()
+	}
+}
@@ -60,6 +60,9 @@ type AuthContext struct {
 	UserID   uint
 	Username string
 	IsAdmin  bool
+	// ViaAPIKey is set when the request authenticated with X-API-Key rather
+	// than a session.
+	ViaAPIKey bool
 }
 
 // AuthRequired now only needs the session store.
@@ -72,8 +75,9 @@ func AuthRequired(store *session.Store, db *gorm.DB) fiber.Handler {
 			if err := db.Where("api_key = ?", apiKey).First(&user).Error; err == nil {
 				// Success! Set the context and move to the route
 				ctx.Locals("auth_ctx", AuthContext{
-					UserID:   user.ID,
-					Username: user.Username,
+					UserID:    user.ID,
+					Username:  user.Username,
+					ViaAPIKey: true,
 				})
 				return ctx.Next()
 			}
@@ -152,6 +156,7 @@ func Logout(store *session.Store) fiber.Handler {
 type RegistrationRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	Team     string `json:"team"`
 }
 
 // Register is the handler for creating a new user account.
@@ -215,6 +220,7 @@ func Register(db *gorm.DB) fiber.Handler {
 		newUser := models.User{
 			Username:     req.Username,
 			PasswordHash: string(hashedPassword),
+			Team:         req.Team,
 		}
 
 		if result := db.Create(&newUser); result.Error != nil {
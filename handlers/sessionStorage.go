@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"jobScheduler/logger"
+	"jobScheduler/models"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// sessionCleanupInterval is how often expired sessions are removed.
+const sessionCleanupInterval = time.Hour
+
+// sessionStorage keeps login sessions in the database, so that every
+// instance sharing it accepts the session cookie.
+type sessionStorage struct {
+	db *gorm.DB
+}
+
+// NewSessionStorage returns session storage backed by db, and removes expired
+// sessions from it every sessionCleanupInterval.
+func NewSessionStorage(db *gorm.DB) fiber.Storage {
+	go func() {
+		ticker := time.NewTicker(sessionCleanupInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			err := db.Where("expires_at > ? AND expires_at < ?", time.Time{}, now).Delete(&models.Session{}).Error
+			if err != nil {
+				logger.L.Error("Failed to remove expired sessions", "error", err)
+			}
+		}
+	}()
+	return &sessionStorage{db: db}
+}
+
+func (s *sessionStorage) Get(key string) ([]byte, error) {
+	var session models.Session
+	result := s.db.Where("key = ?", key).Limit(1).Find(&session)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil, result.Error
+	}
+	if !session.ExpiresAt.IsZero() && session.ExpiresAt.Before(time.Now()) {
+		return nil, nil
+	}
+	return session.Data, nil
+}
+
+func (s *sessionStorage) Set(key string, value []byte, expiration time.Duration) error {
+	if key == "" || len(value) == 0 {
+		return nil
+	}
+	session := models.Session{Key: key, Data: value}
+	if expiration > 0 {
+		session.ExpiresAt = time.Now().Add(expiration)
+	}
+	return s.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&session).Error
+}
+
+func (s *sessionStorage) Delete(key string) error {
+	if key == "" {
+		return nil
+	}
+	return s.db.Where("key = ?", key).Delete(&models.Session{}).Error
+}
+
+func (s *sessionStorage) Reset() error {
+	return s.db.Where("1 = 1").Delete(&models.Session{}).Error
+}
+
+func (s *sessionStorage) Close() error {
+	return nil
+}
@@ -1,16 +1,22 @@
 package main
 
 import (
+	"context"
 	"io"
+	"jobScheduler/agent"
 	"jobScheduler/config"
 	"jobScheduler/handlers"
 	"jobScheduler/logger"
 	"jobScheduler/models"
 	"jobScheduler/routes"
+	"jobScheduler/secrets"
 	"jobScheduler/worker"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
+	_ "time/tzdata" // job schedules may name any IANA time zone
 
 	"github.com/glebarez/sqlite" // Pure Go SQLite driver
 	"github.com/gofiber/fiber/v2"
@@ -36,7 +42,16 @@ func main() {
 
 	log.SetOutput(multiWriter)
 
-	db, err := gorm.Open(sqlite.Open("dispatch.db"), &gorm.Config{})
+	if len(os.Args) > 2 && os.Args[1] == "worker" && os.Args[2] == "agent" {
+		runAgent(os.Args[3:])
+		return
+	}
+
+	// Several instances may share the database file: writers wait for each
+	// other rather than failing with "database is locked". Transactions take
+	// the write lock when they begin, since one that read first could not
+	// wait for it.
+	db, err := gorm.Open(sqlite.Open("dispatch.db?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate"), &gorm.Config{})
 	if err != nil {
 		logger.L.Error("Failed to connect to database", "error", err)
 		os.Exit(1)
@@ -44,15 +59,25 @@ func main() {
 
 	logger.L.Info("Database connection successful using SQLite.")
 
-	err = db.AutoMigrate(&models.Job{}, &models.User{}, &models.JobExecution{})
+	err = db.AutoMigrate(&models.Job{}, &models.User{}, &models.JobExecution{}, &models.Secret{}, &models.QueueItem{}, &models.Agent{}, &models.LeaderLease{}, &models.Instance{}, &models.Session{})
 	if err != nil {
-		logger.L.Error("Failed to migrate tables", err)
+		logger.L.Error("Failed to migrate tables", "error", err)
+		os.Exit(1)
+	}
+
+	if err := models.MigrateJobStatuses(db); err != nil {
+		logger.L.Error("Failed to migrate job statuses", "error", err)
+		os.Exit(1)
+	}
+
+	if err := models.MigrateJobSpecs(db); err != nil {
+		logger.L.Error("Failed to migrate job specs", "error", err)
 		os.Exit(1)
 	}
 
 	adminCredential, err := config.GetAdminCredential()
 	if err != nil {
-		logger.L.Error("Failed to get admin credential", err)
+		logger.L.Error("Failed to get admin credential", "error", err)
 		os.Exit(1)
 	}
 
@@ -60,6 +85,7 @@ func main() {
 
 	// Updated Session Configuration
 	store := session.New(session.Config{
+		Storage:        handlers.NewSessionStorage(db),
 		Expiration:     24 * time.Hour,
 		CookieHTTPOnly: true,
 		CookieSameSite: "Lax", // Better for local dev
@@ -69,11 +95,21 @@ func main() {
 	workerConfig, err := config.NewWorkerConfig()
 
 	if err != nil {
-		logger.L.Error("Failed to create worker config:", err)
+		logger.L.Error("Failed to create worker config", "error", err)
+		os.Exit(1)
+	}
+
+	secretsConfig, err := config.NewSecretsConfig()
+	if err != nil {
+		logger.L.Error("Failed to create secrets config", "error", err)
+		os.Exit(1)
+	}
+	if err := secrets.Configure(secretsConfig.MasterKey); err != nil {
+		logger.L.Error("Failed to configure secrets", "error", err)
 		os.Exit(1)
 	}
 
-	worker.StartWorkerPool(workerConfig.Workers, workerConfig.QueueSize, db)
+	worker.StartWorkerPool(workerConfig, db)
 
 	app := fiber.New()
 
@@ -92,6 +128,15 @@ func main() {
 
 	api.Post("/login", handlers.Login(db, store))
 
+	// Worker agents authenticate with AGENT_TOKEN instead of a session.
+	agentAuth := handlers.AgentAuthRequired(workerConfig.AgentToken)
+	api.Post("/agent/register", agentAuth, routes.RegisterAgent(db))
+	api.Post("/agent/:id/heartbeat", agentAuth, routes.AgentHeartbeat(db))
+	api.Get("/agent/:id/lease", agentAuth, routes.LeaseRun(db))
+	api.Post("/agent/:id/executions/:executionId/start", agentAuth, routes.StartAgentRun(db))
+	api.Post("/agent/:id/executions/:executionId/output", agentAuth, routes.AgentOutput(db))
+	api.Post("/agent/:id/executions/:executionId/result", agentAuth, routes.AgentResult(db))
+
 	api.Use(handlers.AuthRequired(store, db))
 
 	api.Post("/logout", handlers.Logout(store))
@@ -107,12 +152,62 @@ func main() {
 	api.Get("/jobs", routes.ListJobs(db))
 	api.Get("/job/:id", routes.GetJobDetails(db))
 	api.Get("/job/:id/history", routes.ListJobHistory(db))
+	api.Get("/job/:id/next-runs", routes.ListNextRuns(db))
+	api.Post("/job/:id/run", routes.RunJob(db))
+	api.Post("/schedule/preview", routes.PreviewSchedule())
+	api.Get("/queues", routes.ListQueues(db))
+	api.Get("/agents", routes.ListAgents(db))
+	api.Get("/leader", routes.GetLeader(db))
 	api.Get("/executions", routes.ListAllExecutions(db))
+	api.Get("/execution/:id", routes.GetExecution(db))
+	api.Post("/execution/:id/cancel", routes.CancelExecution(db))
+	api.Get("/execution/:id/log", routes.ExecutionLog(db))
+	api.Get("/execution/:id/stream", routes.StreamExecution(db))
+
+	api.Get("/secrets", routes.ListSecrets(db))
+	api.Post("/secrets", routes.CreateSecret(db))
+	api.Put("/secrets/:id", routes.UpdateSecret(db))
+	api.Delete("/secrets/:id", routes.DeleteSecret(db))
 
 	api.Get("/profile", routes.Profile())
 	api.Get("/users", routes.ListUsers(db))
 
 	api.Post("/generate-api-key", routes.GenerateAPIKey(db))
 
-	app.Listen("0.0.0.0:3000")
+	// Hand the scheduling loop to another instance right away on shutdown.
+	go func() {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		<-ctx.Done()
+		worker.ResignLeadership(db)
+		worker.UnregisterInstance(db)
+		if err := app.Shutdown(); err != nil {
+			logger.L.Error("Failed to shut down the server", "error", err)
+		}
+	}()
+
+	if err := app.Listen(config.GetListenAddr()); err != nil {
+		logger.L.Error("Server stopped", "error", err)
+	}
+}
+
+// runAgent runs this process as a worker agent of another scheduler, see
+// package agent. It stops taking runs on SIGINT or SIGTERM and exits once the
+// running ones have finished.
+func runAgent(args []string) {
+	agentConfig, err := config.NewAgentConfig(args)
+	if err != nil {
+		logger.L.Error("Failed to create agent config", "error", err)
+		log.Println("Failed to create agent config:", err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	log.Printf("Agent %s taking runs from %s (queues %v)", agentConfig.Name, agentConfig.ServerURL, agentConfig.Queues)
+	if err := agent.Run(ctx, agentConfig); err != nil && ctx.Err() == nil {
+		logger.L.Error("Agent failed", "error", err)
+		log.Println("Agent failed:", err)
+		os.Exit(1)
+	}
 }
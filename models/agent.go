@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// Agent is a worker process on another machine that leases runs over HTTP.
+// Every start of an agent registers it anew.
+type Agent struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Name      string    `json:"name" gorm:"index"`
+	Hostname  string    `json:"hostname"`
+	// Labels describe the agent, e.g. {"gpu": "true", "region": "eu"}.
+	Labels map[string]string `json:"labels,omitempty" gorm:"serializer:json"`
+	// Queues are the queues the agent takes runs from.
+	Queues []string `json:"queues" gorm:"serializer:json"`
+	// Workers is how many runs the agent executes at once.
+	Workers         int       `json:"workers"`
+	LastHeartbeatAt time.Time `json:"lastHeartbeatAt" gorm:"index"`
+}
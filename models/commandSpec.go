@@ -0,0 +1,131 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Shells a command job can run in. ShellNone executes the command directly,
+// split into arguments, without a shell.
+const (
+	ShellSh   = "sh"
+	ShellBash = "bash"
+	ShellNone = "none"
+)
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// IsValidEnvName reports whether name can be used as an environment variable.
+func IsValidEnvName(name string) bool {
+	return envNamePattern.MatchString(name)
+}
+
+// CommandSpec is the optional Spec of a job of type "command".
+type CommandSpec struct {
+	Env        map[string]string `json:"env,omitempty"`
+	WorkingDir string            `json:"workingDir,omitempty"` // absolute; defaults to the server's directory
+	Shell      string            `json:"shell,omitempty"`      // sh (default), bash or none
+	// CleanEnv starts the command with only a default PATH and Env instead
+	// of the server's environment.
+	CleanEnv bool `json:"cleanEnv,omitempty"`
+}
+
+// Validate checks the spec of a job that runs command.
+func (s *CommandSpec) Validate(command string) error {
+	for name := range s.Env {
+		if !IsValidEnvName(name) {
+			return fmt.Errorf("invalid env name %q: must be letters, digits and underscores, not starting with a digit", name)
+		}
+	}
+
+	if s.WorkingDir != "" {
+		if !filepath.IsAbs(s.WorkingDir) {
+			return fmt.Errorf("invalid workingDir %q: must be an absolute path", s.WorkingDir)
+		}
+		info, err := os.Stat(s.WorkingDir)
+		if err != nil {
+			return fmt.Errorf("invalid workingDir %q: %v", s.WorkingDir, err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("invalid workingDir %q: not a directory", s.WorkingDir)
+		}
+	}
+
+	switch s.Shell {
+	case "", ShellSh, ShellBash:
+	case ShellNone:
+		args, err := SplitCommand(command)
+		if err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			return errors.New("command is required")
+		}
+	default:
+		return fmt.Errorf("invalid shell %q: must be sh, bash or none", s.Shell)
+	}
+
+	return nil
+}
+
+// SplitCommand splits a command into arguments the way a shell would split
+// words, honouring single quotes, double quotes and backslash escapes. It
+// does not expand variables or globs.
+func SplitCommand(command string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inWord := false
+	var quote rune
+
+	runes := []rune(command)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case quote == '"':
+			switch {
+			case r == '"':
+				quote = 0
+			case r == '\\' && i+1 < len(runes) && strings.ContainsRune(`"\$`+"`", runes[i+1]):
+				i++
+				current.WriteRune(runes[i])
+			default:
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == '\\':
+			if i+1 < len(runes) {
+				i++
+				current.WriteRune(runes[i])
+			}
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				args = append(args, current.String())
+				current.Reset()
+				inWord = false
+			}
+		default:
+			current.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("invalid command: unterminated %c quote", quote)
+	}
+	if inWord {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
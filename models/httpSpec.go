@@ -0,0 +1,231 @@
+package models
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Kinds of HTTP response assertions.
+const (
+	AssertionContains = "contains"  // the body contains Value
+	AssertionRegex    = "regex"     // the body matches the regular expression Value
+	AssertionJSONPath = "json_path" // the JSON body has a value at Path, equal to Value when set
+)
+
+// HTTPAssertion is a check on the body of an HTTP job's response.
+type HTTPAssertion struct {
+	Type  string `json:"type"`
+	Path  string `json:"path,omitempty"` // json_path only, e.g. "data.items[0].status"
+	Value string `json:"value,omitempty"`
+}
+
+// secretReference matches ${NAME}, which an http job replaces with the
+// value of its secret NAME; wholeSecretReference matches a value that is
+// nothing else.
+var (
+	secretReference      = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+	wholeSecretReference = regexp.MustCompile(`^\$\{([A-Za-z_][A-Za-z0-9_]*)\}$`)
+)
+
+// credentialHeaderWords mark headers that carry credentials. Their values
+// must come from secrets, so that they are not stored with the job and
+// shown to everyone who can list it.
+var credentialHeaderWords = []string{"authorization", "cookie", "token", "secret", "password", "key"}
+
+// IsCredentialHeader reports whether the header named name carries
+// credentials, such as Authorization or X-API-Key.
+func IsCredentialHeader(name string) bool {
+	lower := strings.ToLower(name)
+	for _, word := range credentialHeaderWords {
+		if strings.Contains(lower, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// HTTPTLS configures how an HTTP job verifies the server and authenticates
+// itself. Certificates and keys are PEM encoded.
+type HTTPTLS struct {
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+	ServerName         string `json:"serverName,omitempty"`
+	CACert             string `json:"caCert,omitempty"` // trusted in addition to the system roots
+	ClientCert         string `json:"clientCert,omitempty"`
+	// ClientKey names the secret holding the private key, as ${NAME}.
+	ClientKey string `json:"clientKey,omitempty"`
+}
+
+// Validate checks t without the private key, which only the secret
+// ClientKey names holds.
+func (t *HTTPTLS) Validate() error {
+	if t.ClientKey != "" && !wholeSecretReference.MatchString(t.ClientKey) {
+		return errors.New("invalid tls clientKey: must name a secret holding the key, as ${NAME}")
+	}
+	if (t.ClientCert == "") != (t.ClientKey == "") {
+		return errors.New("invalid tls client certificate: clientCert and clientKey go together")
+	}
+	verify := *t
+	verify.ClientCert, verify.ClientKey = "", ""
+	if _, err := verify.Config(); err != nil {
+		return err
+	}
+	if t.ClientCert != "" {
+		if block, _ := pem.Decode([]byte(t.ClientCert)); block == nil || block.Type != "CERTIFICATE" {
+			return errors.New("invalid tls clientCert: no PEM certificate found")
+		}
+	}
+	return nil
+}
+
+// Config builds the tls.Config described by t.
+func (t *HTTPTLS) Config() (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: t.InsecureSkipVerify,
+		ServerName:         t.ServerName,
+	}
+	if t.CACert != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM([]byte(t.CACert)) {
+			return nil, errors.New("invalid tls caCert: no PEM certificates found")
+		}
+		config.RootCAs = pool
+	}
+	if t.ClientCert != "" || t.ClientKey != "" {
+		cert, err := tls.X509KeyPair([]byte(t.ClientCert), []byte(t.ClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid tls client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// HTTPSpec is the Spec of a job of type "http". It describes the request
+// and what counts as a successful response.
+type HTTPSpec struct {
+	Method  string            `json:"method,omitempty"` // defaults to GET
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+	// Timeout limits the request, e.g. "10s". It replaces the job's
+	// timeoutSeconds when set.
+	Timeout string   `json:"timeout,omitempty"`
+	TLS     *HTTPTLS `json:"tls,omitempty"`
+	// ExpectedStatus lists the status codes that count as success. When
+	// empty, any 2xx status does.
+	ExpectedStatus []int           `json:"expectedStatus,omitempty"`
+	Assertions     []HTTPAssertion `json:"assertions,omitempty"`
+}
+
+func (s *HTTPSpec) Validate() error {
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid http url %q: must be an absolute http or https URL", s.URL)
+	}
+
+	switch strings.ToUpper(s.Method) {
+	case "", http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+	default:
+		return fmt.Errorf("invalid http method %q", s.Method)
+	}
+
+	if s.Timeout != "" {
+		timeout, err := time.ParseDuration(s.Timeout)
+		if err != nil {
+			return fmt.Errorf("invalid http timeout %q: %v", s.Timeout, err)
+		}
+		if timeout <= 0 {
+			return fmt.Errorf("invalid http timeout %q: must be positive", s.Timeout)
+		}
+	}
+
+	for name, value := range s.Headers {
+		if IsCredentialHeader(name) && !secretReference.MatchString(value) {
+			return fmt.Errorf("invalid header %s: it carries credentials, so its value must use a secret, e.g. ${API_TOKEN}", name)
+		}
+	}
+
+	if s.TLS != nil {
+		if err := s.TLS.Validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, code := range s.ExpectedStatus {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("invalid expected status %d: must be between 100-599", code)
+		}
+	}
+
+	for _, a := range s.Assertions {
+		switch a.Type {
+		case AssertionContains:
+			if a.Value == "" {
+				return errors.New("invalid contains assertion: value is required")
+			}
+		case AssertionRegex:
+			if _, err := regexp.Compile(a.Value); err != nil {
+				return fmt.Errorf("invalid regex assertion %q: %v", a.Value, err)
+			}
+		case AssertionJSONPath:
+			if a.Path == "" {
+				return errors.New("invalid json_path assertion: path is required")
+			}
+		default:
+			return fmt.Errorf("invalid assertion type %q: must be contains, regex or json_path", a.Type)
+		}
+	}
+
+	return nil
+}
+
+// SecretReferences returns the names of the secrets the credential headers
+// and the tls clientKey use.
+func (s *HTTPSpec) SecretReferences() []string {
+	var names []string
+	for name, value := range s.Headers {
+		if IsCredentialHeader(name) {
+			for _, match := range secretReference.FindAllStringSubmatch(value, -1) {
+				names = append(names, match[1])
+			}
+		}
+	}
+	if s.TLS != nil {
+		for _, match := range secretReference.FindAllStringSubmatch(s.TLS.ClientKey, -1) {
+			names = append(names, match[1])
+		}
+	}
+	return names
+}
+
+// RequestMethod returns the HTTP method, GET when none is set.
+func (s *HTTPSpec) RequestMethod() string {
+	if s.Method == "" {
+		return http.MethodGet
+	}
+	return strings.ToUpper(s.Method)
+}
+
+// ExpectsStatus reports whether a response with the status code counts as success.
+func (s *HTTPSpec) ExpectsStatus(code int) bool {
+	if len(s.ExpectedStatus) == 0 {
+		return code >= 200 && code < 300
+	}
+	for _, expected := range s.ExpectedStatus {
+		if code == expected {
+			return true
+		}
+	}
+	return false
+}
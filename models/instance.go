@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// Instance is a server process sharing the database with others. Instances
+// forward requests about runs, such as log streams and agent reports, to
+// the instance that holds them.
+type Instance struct {
+	ID  string `json:"id" gorm:"primarykey"` // host:pid
+	URL string `json:"url"`                  // where the other instances reach it
+	// Labels and Queues describe the workers of the instance: the queues
+	// that have any.
+	Labels          map[string]string `json:"labels,omitempty" gorm:"serializer:json"`
+	Queues          []string          `json:"queues" gorm:"serializer:json"`
+	StartedAt       time.Time         `json:"startedAt"`
+	LastHeartbeatAt time.Time         `json:"lastHeartbeatAt" gorm:"index"`
+}
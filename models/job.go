@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"time"
 
 	"gorm.io/gorm"
@@ -15,15 +16,52 @@ type ScheduleTime struct {
 	Minute int `json:"minute"`
 }
 
+// Schedule kinds. A calendar schedule fires at wall-clock Times on matching
+// dates; an interval schedule fires every Interval of elapsed time.
+const (
+	ScheduleKindCalendar = "calendar"
+	ScheduleKindInterval = "interval"
+)
+
 type Schedule struct {
+	Kind        string         `json:"kind,omitempty"` // defaults to "calendar"
 	Years       []int          `json:"years,omitempty"`
 	Months      []int          `json:"months,omitempty"`
 	DaysOfMonth []int          `json:"daysOfMonth,omitempty"`
 	Weekdays    []time.Weekday `json:"weekdays,omitempty"`
 	Times       []ScheduleTime `json:"times"`
+	Timezone    string         `json:"timezone,omitempty"` // IANA name, e.g. "Europe/Berlin"
+
+	// Interval schedules only. Durations use Go syntax such as "90s" or "7m".
+	Interval string     `json:"interval,omitempty"`
+	Anchor   *time.Time `json:"anchor,omitempty"`  // a time the interval is aligned to
+	StartAt  *time.Time `json:"startAt,omitempty"` // no runs before this time
+	EndAt    *time.Time `json:"endAt,omitempty"`   // no runs after this time
+	Jitter   string     `json:"jitter,omitempty"`  // random delay added to each run
+}
+
+// Location returns the time zone the schedule is evaluated in. Schedules
+// without a Timezone use the server's local time zone.
+func (s *Schedule) Location() (*time.Location, error) {
+	if s.Timezone == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(s.Timezone)
 }
 
 func (s *Schedule) Validate() error {
+	if _, err := s.Location(); err != nil {
+		return fmt.Errorf("invalid timezone %q: %v", s.Timezone, err)
+	}
+
+	switch s.Kind {
+	case "", ScheduleKindCalendar:
+	case ScheduleKindInterval:
+		return s.validateInterval()
+	default:
+		return fmt.Errorf("invalid schedule kind %q: must be calendar or interval", s.Kind)
+	}
+
 	// --- THIS IS THE NEW VALIDATION LOGIC FOR THE YEAR ---
 	// Get the current year once for comparison.
 	currentYear := time.Now().Year()
@@ -57,6 +95,36 @@ func (s *Schedule) Validate() error {
 	return nil
 }
 
+func (s *Schedule) validateInterval() error {
+	if len(s.Times) > 0 || len(s.Years) > 0 || len(s.Months) > 0 || len(s.DaysOfMonth) > 0 || len(s.Weekdays) > 0 {
+		return errors.New("interval schedules cannot have times, years, months, days of month or weekdays")
+	}
+
+	interval, err := time.ParseDuration(s.Interval)
+	if err != nil {
+		return fmt.Errorf("invalid interval %q: %v", s.Interval, err)
+	}
+	if interval < time.Second {
+		return fmt.Errorf("invalid interval %q: must be at least 1s", s.Interval)
+	}
+
+	if s.Jitter != "" {
+		jitter, err := time.ParseDuration(s.Jitter)
+		if err != nil {
+			return fmt.Errorf("invalid jitter %q: %v", s.Jitter, err)
+		}
+		if jitter < 0 || jitter >= interval {
+			return fmt.Errorf("invalid jitter %q: must be between 0 and the interval", s.Jitter)
+		}
+	}
+
+	if s.StartAt != nil && s.EndAt != nil && !s.EndAt.After(*s.StartAt) {
+		return errors.New("invalid interval bounds: endAt must be after startAt")
+	}
+
+	return nil
+}
+
 // GORM requires these two methods to handle custom types like jsonb.
 func (s Schedule) Value() (driver.Value, error) {
 	return json.Marshal(s)
@@ -69,14 +137,293 @@ func (s *Schedule) Scan(value interface{}) error {
 	return json.Unmarshal(b, &s)
 }
 
+// Defaults for the optional fields of a RetryPolicy.
+const (
+	DefaultRetryInitialDelay = 10 * time.Second
+	DefaultRetryMultiplier   = 2.0
+	DefaultRetryMaxDelay     = time.Hour
+)
+
+// RetryPolicy says how often and how soon a failed run of a job is tried
+// again. Every attempt is recorded as its own JobExecution.
+type RetryPolicy struct {
+	MaxAttempts  int     `json:"maxAttempts,omitempty"`  // attempts in total, including the first; 0 or 1 disables retries
+	InitialDelay string  `json:"initialDelay,omitempty"` // delay before the second attempt, e.g. "30s"
+	Multiplier   float64 `json:"multiplier,omitempty"`   // factor applied to the delay after every attempt
+	MaxDelay     string  `json:"maxDelay,omitempty"`     // upper bound for the delay
+	// RetryOnExitCodes limits retries to failures with one of these exit
+	// codes. When empty, every failure and timeout is retried.
+	RetryOnExitCodes []int `json:"retryOnExitCodes,omitempty"`
+}
+
+func (r *RetryPolicy) Validate() error {
+	if r.MaxAttempts < 0 {
+		return errors.New("invalid retry policy: maxAttempts must not be negative")
+	}
+	if err := validateRetryDelay("initialDelay", r.InitialDelay); err != nil {
+		return err
+	}
+	if err := validateRetryDelay("maxDelay", r.MaxDelay); err != nil {
+		return err
+	}
+	if r.Multiplier != 0 && r.Multiplier < 1 {
+		return errors.New("invalid retry policy: multiplier must be at least 1")
+	}
+	for _, code := range r.RetryOnExitCodes {
+		if code < 1 || code > 255 {
+			return fmt.Errorf("invalid retry exit code %d: must be between 1-255", code)
+		}
+	}
+	return nil
+}
+
+func validateRetryDelay(name, value string) error {
+	if value == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid retry %s %q: %v", name, value, err)
+	}
+	if d < 0 {
+		return fmt.Errorf("invalid retry %s %q: must not be negative", name, value)
+	}
+	return nil
+}
+
+// Delay returns how long to wait after the given failed attempt, counting
+// from 1, before the next one starts.
+func (r *RetryPolicy) Delay(attempt int) time.Duration {
+	delay, maxDelay := DefaultRetryInitialDelay, DefaultRetryMaxDelay
+	if d, err := time.ParseDuration(r.InitialDelay); err == nil {
+		delay = d
+	}
+	if d, err := time.ParseDuration(r.MaxDelay); err == nil {
+		maxDelay = d
+	}
+	multiplier := r.Multiplier
+	if multiplier == 0 {
+		multiplier = DefaultRetryMultiplier
+	}
+
+	backoff := float64(delay) * math.Pow(multiplier, float64(attempt-1))
+	if backoff > float64(maxDelay) {
+		return maxDelay
+	}
+	return time.Duration(backoff)
+}
+
+// Retries reports whether a failure with the given exit code is retried.
+// Timeouts and failures without an exit code pass -1.
+func (r *RetryPolicy) Retries(exitCode int) bool {
+	if len(r.RetryOnExitCodes) == 0 {
+		return true
+	}
+	for _, code := range r.RetryOnExitCodes {
+		if code == exitCode {
+			return true
+		}
+	}
+	return false
+}
+
+func (r RetryPolicy) Value() (driver.Value, error) {
+	return json.Marshal(r)
+}
+func (r *RetryPolicy) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		// Jobs stored before retries existed.
+		*r = RetryPolicy{}
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), r)
+	case []byte:
+		return json.Unmarshal(v, r)
+	}
+	return errors.New("type assertion to []byte failed")
+}
+
+// Scheduling states of a Job. They only say whether the scheduler should
+// fire the job; the outcome of its most recent run is kept in LastStatus.
+const (
+	JobStatusEnabled  = "enabled"
+	JobStatusPaused   = "paused"
+	JobStatusDisabled = "disabled"
+	// JobStatusCompleted marks a one-shot job whose schedule has no
+	// occurrences left. It is set by the scheduler, never by users.
+	JobStatusCompleted = "completed"
+)
+
+// IsSettableJobStatus reports whether a user may put a job into status.
+func IsSettableJobStatus(status string) bool {
+	switch status {
+	case JobStatusEnabled, JobStatusPaused, JobStatusDisabled:
+		return true
+	}
+	return false
+}
+
+// Misfire policies decide what happens to fire times that passed without
+// the job being queued, because the process was down or the queue was full.
+const (
+	MisfirePolicySkip    = "skip"     // drop missed runs
+	MisfirePolicyRunOnce = "run_once" // run once for any number of missed runs
+	MisfirePolicyRunAll  = "run_all"  // run every missed run, up to MisfireLimit
+)
+
+// DefaultMisfireLimit caps catch-up runs for run_all when MisfireLimit is unset.
+const DefaultMisfireLimit = 10
+
+// IsValidMisfirePolicy reports whether policy names a known misfire policy.
+func IsValidMisfirePolicy(policy string) bool {
+	switch policy {
+	case MisfirePolicySkip, MisfirePolicyRunOnce, MisfirePolicyRunAll:
+		return true
+	}
+	return false
+}
+
+// Concurrency policies decide what happens when a job is started while an
+// earlier run of it is still executing.
+const (
+	ConcurrencyPolicyAllow   = "allow"   // run both at the same time
+	ConcurrencyPolicyForbid  = "forbid"  // skip the new run
+	ConcurrencyPolicyReplace = "replace" // cancel the running one, then start
+	ConcurrencyPolicyQueue   = "queue"   // wait for the running one to finish
+)
+
+// IsValidConcurrencyPolicy reports whether policy names a known concurrency policy.
+func IsValidConcurrencyPolicy(policy string) bool {
+	switch policy {
+	case ConcurrencyPolicyAllow, ConcurrencyPolicyForbid, ConcurrencyPolicyReplace, ConcurrencyPolicyQueue:
+		return true
+	}
+	return false
+}
+
+// Job types. A command job runs Command through the shell; an http job
+// makes the request described by its Spec, an HTTPSpec.
+const (
+	JobTypeCommand = "command"
+	JobTypeHTTP    = "http"
+)
+
 type Job struct {
 	gorm.Model
-	Name      string     `json:"name" gorm:"not null"`
-	Command   string     `json:"command" gorm:"not null"`
-	Schedule  Schedule   `json:"schedule" gorm:"type:jsonb"`
-	Status    string     `json:"status" gorm:"default:'pending'"`
-	LastRunAt *time.Time `json:"lastRunAt,omitempty"`
-	UserID    uint       `json:"userId"`
+	Name       string     `json:"name" gorm:"not null"`
+	Command    string     `json:"command" gorm:"not null"`
+	Schedule   Schedule   `json:"schedule" gorm:"type:jsonb"`
+	Cron       string     `json:"cron,omitempty"` // used instead of Schedule when set
+	Status     string     `json:"status" gorm:"default:'enabled'"`
+	LastStatus string     `json:"lastStatus,omitempty"` // e.g., "running", "succeeded" or "failed"
+	LastRunAt  *time.Time `json:"lastRunAt,omitempty"`
+	NextRunAt  *time.Time `json:"nextRunAt,omitempty"`
+	UserID     uint       `json:"userId"`
+
+	// TimeoutSeconds limits how long a run may take before its process
+	// group is terminated. Zero uses the server's JOB_TIMEOUT_SECONDS.
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+
+	MisfirePolicy string `json:"misfirePolicy" gorm:"default:'skip'"`
+	MisfireLimit  int    `json:"misfireLimit,omitempty"` // run_all only; DefaultMisfireLimit when zero
+	// LastScheduledAt is the instant up to which the scheduler has dealt
+	// with the job's fire times. Anything after it and already in the past
+	// was missed.
+	LastScheduledAt *time.Time `json:"lastScheduledAt,omitempty"`
+
+	Retry RetryPolicy `json:"retry" gorm:"type:jsonb"`
+
+	ConcurrencyPolicy string `json:"concurrencyPolicy" gorm:"default:'allow'"`
+
+	// Queue names the worker queue that runs the job. Within a queue, runs
+	// with a higher Priority are started first.
+	Queue    string `json:"queue" gorm:"default:'default'"`
+	Priority int    `json:"priority"`
+	// NodeSelector limits the job to workers and agents that have all of
+	// these labels with the same values, e.g. {"gpu": "true"}.
+	NodeSelector map[string]string `json:"nodeSelector,omitempty" gorm:"serializer:json"`
+
+	// Type selects the executor that runs the job, and Spec holds the
+	// executor's settings in a format of its own.
+	Type string  `json:"type" gorm:"default:'command'"`
+	Spec JobSpec `json:"spec,omitempty" gorm:"type:jsonb"`
+
+	// Secrets names the secrets passed to the job as environment variables
+	// of the same name.
+	Secrets []string `json:"secrets,omitempty" gorm:"serializer:json"`
+}
+
+// JobSpec is the JSON settings of a job for the executor of its Type.
+type JobSpec json.RawMessage
+
+func (s JobSpec) MarshalJSON() ([]byte, error) {
+	if len(s) == 0 {
+		return []byte("null"), nil
+	}
+	return s, nil
+}
+func (s *JobSpec) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*s = nil
+		return nil
+	}
+	*s = append((*s)[:0], data...)
+	return nil
+}
+
+// IsEmpty reports whether no spec is set.
+func (s JobSpec) IsEmpty() bool {
+	return len(s) == 0 || string(s) == "null" || string(s) == "{}"
+}
+
+func (s JobSpec) Value() (driver.Value, error) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+	return []byte(s), nil
+}
+func (s *JobSpec) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*s = nil
+		return nil
+	case string:
+		*s = JobSpec(v)
+		return nil
+	case []byte:
+		*s = append(JobSpec(nil), v...)
+		return nil
+	}
+	return errors.New("type assertion to []byte failed")
+}
+
+// MigrateJobStatuses converts jobs stored before the scheduling state was
+// split from the run outcome, when Status still held "pending", "running",
+// "succeeded" or "failed".
+func MigrateJobStatuses(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Job{}).
+			Where("status IN ?", []string{"succeeded", "failed"}).
+			Update("last_status", gorm.Expr("status")).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&Job{}).
+			Where("status IN ?", []string{"pending", "running", "succeeded", "failed"}).
+			Update("status", JobStatusEnabled).Error
+	})
+}
+
+// MigrateJobSpecs moves the settings of http jobs stored in the former
+// http column into Spec.
+func MigrateJobSpecs(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&Job{}, "http") {
+		return nil
+	}
+	return db.Model(&Job{}).
+		Where("type = ? AND spec IS NULL AND http IS NOT NULL", JobTypeHTTP).
+		Update("spec", gorm.Expr("http")).Error
 }
 
 type ExecuteJob struct {
@@ -88,11 +435,90 @@ type ExecuteJob struct {
 	UserID    uint       `json:"userId"`
 }
 
+// Statuses of a JobExecution.
+const (
+	ExecutionStatusQueued    = "queued" // waiting for a worker
+	ExecutionStatusRunning   = "running"
+	ExecutionStatusSucceeded = "succeeded"
+	ExecutionStatusFailed    = "failed"
+	ExecutionStatusTimedOut  = "timed_out"
+	ExecutionStatusCancelled = "cancelled"
+	ExecutionStatusSkipped   = "skipped" // not run because of the forbid concurrency policy
+	// ExecutionStatusAbandoned marks executions whose worker went away while
+	// they were running, e.g. because the server crashed.
+	ExecutionStatusAbandoned = "abandoned"
+	// ExecutionStatusUnschedulable marks runs that no worker could take
+	// because none matched the job's queue and node selector.
+	ExecutionStatusUnschedulable = "unschedulable"
+)
+
+// How the job's concurrency policy handled an execution, as recorded in
+// JobExecution.Concurrency.
+const (
+	ConcurrencyDecisionStarted  = "started"  // no other run was executing
+	ConcurrencyDecisionAllowed  = "allowed"  // ran alongside another run
+	ConcurrencyDecisionSkipped  = "skipped"  // dropped because another run was executing
+	ConcurrencyDecisionReplaced = "replaced" // started after cancelling the other runs
+	ConcurrencyDecisionQueued   = "queued"   // started after the other runs finished
+)
+
+// LastStatusRetrying is a job's LastStatus while a failed run waits for its
+// next attempt. The run's final outcome replaces it once retries are done.
+const LastStatusRetrying = "retrying"
+
+// What started a run, as recorded in JobExecution.Trigger.
+const (
+	TriggerSchedule = "schedule" // the job's schedule fired, including missed runs
+	TriggerManual   = "manual"   // started by a logged-in user
+	TriggerAPI      = "api"      // started by a request authenticated with an API key
+	TriggerRetry    = "retry"    // a later attempt of a failed run
+)
+
 type JobExecution struct {
 	gorm.Model
-	Status     string    `json:"status"` // e.g., "succeeded" or "failed"
-	Output     string    `json:"output" gorm:"type:text"`
-	FinishedAt time.Time `json:"finishedAt"`
-	JobID      uint      `json:"jobId"`
-	Job        Job       `json:"-" gorm:"foreignKey:JobID"`
+	Status string `json:"status"` // e.g., "queued", "running", "succeeded", "failed", "timed_out", "cancelled", "skipped" or "abandoned"
+	// Output interleaves stdout and stderr in the order they were written.
+	// Output, Stdout and Stderr only keep the head and tail of long output;
+	// all of it is in the log file.
+	Output          string    `json:"output" gorm:"type:text"`
+	Stdout          string    `json:"stdout,omitempty" gorm:"type:text"`
+	Stderr          string    `json:"stderr,omitempty" gorm:"type:text"`
+	OutputBytes     int64     `json:"outputBytes"`
+	OutputTruncated bool      `json:"outputTruncated,omitempty"`
+	LogFile         string    `json:"-"` // gzipped full output; empty once removed
+	StartedAt       time.Time `json:"startedAt"`
+	FinishedAt      time.Time `json:"finishedAt"`
+	DurationMs      int64     `json:"durationMs"`
+	CancelledBy     string    `json:"cancelledBy,omitempty"` // username of whoever cancelled the run
+	// ExitCode is set once a command has exited on its own; Signal names the
+	// signal that stopped it otherwise.
+	ExitCode *int   `json:"exitCode,omitempty"`
+	Signal   string `json:"signal,omitempty"`
+	// Trigger is what started the run: schedule, manual, api or retry.
+	Trigger string `json:"trigger,omitempty"`
+	// Params are the parameters the run was started with, and Command the
+	// job's command as rendered with them if it is a template.
+	Params  map[string]string `json:"params,omitempty" gorm:"serializer:json"`
+	Command string            `json:"command,omitempty"`
+	// Queue is the worker queue the run went through, and WorkerID the
+	// worker of that queue, or of Agent, that ran it.
+	Queue    string `json:"queue,omitempty"`
+	WorkerID int    `json:"workerId,omitempty"` // zero when run outside the worker pool
+	Agent    string `json:"agent,omitempty"`    // name of the agent that ran it, if any
+	Hostname string `json:"hostname,omitempty"`
+	// Instance is the server instance that ran the execution, or relayed it
+	// to its agent. Its log file is kept there.
+	Instance string `json:"instance,omitempty"`
+	// RunID is the ID of the first execution of the run; retries share it.
+	RunID   uint `json:"runId"`
+	Attempt int  `json:"attempt"` // counts from 1
+	// Concurrency is the decision of the job's concurrency policy.
+	Concurrency string `json:"concurrency,omitempty"`
+
+	// Filled in for http jobs.
+	StatusCode int    `json:"statusCode,omitempty"`
+	LatencyMs  int64  `json:"latencyMs,omitempty"`
+	Response   string `json:"response,omitempty" gorm:"type:text"` // truncated response body
+	JobID      uint   `json:"jobId"`
+	Job        Job    `json:"-" gorm:"foreignKey:JobID"`
 }
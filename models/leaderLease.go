@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// SchedulerLeaderLease names the lease held by the instance that runs the
+// scheduling loop.
+const SchedulerLeaderLease = "scheduler"
+
+// LeaderLease elects one of several instances sharing the database to do
+// something only one of them may do. The holder renews it while it lives;
+// once it has expired, any instance may take it over.
+type LeaderLease struct {
+	Name   string `json:"name" gorm:"primarykey"`
+	Holder string `json:"holder"` // instance ID, as host:pid
+	// Term is incremented every time the lease changes hands.
+	Term       uint      `json:"term"`
+	AcquiredAt time.Time `json:"acquiredAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
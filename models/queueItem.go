@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// DefaultQueue is the queue of jobs that do not name one.
+const DefaultQueue = "default"
+
+// States of a QueueItem.
+const (
+	QueueStateEnqueued = "enqueued" // waiting for a worker
+	QueueStateLeased   = "leased"   // taken by a worker until LeaseExpiresAt
+	QueueStateDone     = "done"     // run, cancelled or abandoned
+)
+
+// QueueItem is a run waiting for, or held by, a worker. Runs are queued in
+// the database so that they survive a restart; each has a queued
+// JobExecution that the worker fills in once it starts.
+type QueueItem struct {
+	ID          uint              `json:"id" gorm:"primarykey"`
+	CreatedAt   time.Time         `json:"createdAt"`
+	UpdatedAt   time.Time         `json:"updatedAt"`
+	JobID       uint              `json:"jobId" gorm:"index"`
+	ExecutionID uint              `json:"executionId" gorm:"index"`
+	Queue       string            `json:"queue" gorm:"index;default:'default'"`
+	Priority    int               `json:"priority"`
+	RunID       uint              `json:"runId"`
+	Attempt     int               `json:"attempt"` // attempt of the run, see JobExecution.Attempt
+	Trigger     string            `json:"trigger"`
+	Params      map[string]string `json:"params,omitempty" gorm:"serializer:json"`
+	State       string            `json:"state" gorm:"index;not null"`
+	// NodeSelector is the job's node selector when the run was queued.
+	NodeSelector map[string]string `json:"nodeSelector,omitempty" gorm:"serializer:json"`
+	// AvailableAt is when the item may be leased; later than CreatedAt for
+	// retries waiting out their delay.
+	AvailableAt time.Time `json:"availableAt" gorm:"index"`
+	// LeasedBy names the worker holding the lease, as host:pid/worker.
+	LeasedBy       string     `json:"leasedBy,omitempty"`
+	LeaseExpiresAt *time.Time `json:"leaseExpiresAt,omitempty"`
+	// Deliveries counts how often the item has been leased. An item whose
+	// lease keeps expiring before its execution starts is given up on.
+	Deliveries int `json:"deliveries"`
+	// CancelRequested is set when the run was cancelled while an agent
+	// executes it, so that any instance can tell the agent.
+	CancelRequested bool       `json:"cancelRequested,omitempty"`
+	FinishedAt      *time.Time `json:"finishedAt,omitempty"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Secret is an encrypted value that jobs receive as an environment variable
+// named after it. A secret belongs either to one user or, when Team is set,
+// to every member of that team.
+type Secret struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Name      string    `json:"name" gorm:"not null;uniqueIndex:idx_secret_scope"`
+	UserID    uint      `json:"userId,omitempty" gorm:"uniqueIndex:idx_secret_scope"` // zero for team secrets
+	Team      string    `json:"team,omitempty" gorm:"uniqueIndex:idx_secret_scope"`
+	// Ciphertext is the AES-GCM sealed value, prefixed with its nonce.
+	Ciphertext []byte `json:"-" gorm:"not null"`
+	UpdatedBy  string `json:"updatedBy"`
+}
+
+// AdditionalData identifies the secret to the cipher, so that a ciphertext
+// only decrypts for the secret it was written for.
+func (s *Secret) AdditionalData() string {
+	return fmt.Sprintf("secret:%d:%s:%s", s.UserID, s.Team, s.Name)
+}
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// Session is a login session, stored in the database so that every
+// instance sharing it accepts the session cookie.
+type Session struct {
+	Key       string    `gorm:"primarykey"`
+	Data      []byte    `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"index"`
+}
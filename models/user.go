@@ -9,4 +9,6 @@ type User struct {
 	PasswordHash string `json:"-" gorm:"not null"`
 	IsAdmin      bool   `json:"isAdmin" gorm:"not null"`
 	APIKey       string `gorm:"unique"`
+	// Team lets users share secrets. Users without a team only have their own.
+	Team string `json:"team,omitempty"`
 }
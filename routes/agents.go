@@ -0,0 +1,276 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"jobScheduler/logger"
+	"jobScheduler/models"
+	"jobScheduler/structs"
+	"jobScheduler/worker"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// defaultLeaseWait is how long a lease request waits for a run without
+// ?wait.
+const defaultLeaseWait = 30 * time.Second
+
+// findAgent loads the agent named by the :id parameter. If it cannot, ok is
+// false and err is the response that has been sent.
+func findAgent(db *gorm.DB, ctx *fiber.Ctx) (agent models.Agent, ok bool, err error) {
+	agentID, err := ctx.ParamsInt("id")
+	if err != nil || agentID <= 0 {
+		return agent, false, ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid agent id",
+		})
+	}
+	agent, err = worker.LoadAgent(db, uint(agentID))
+	if errors.Is(err, worker.ErrUnknownAgent) {
+		return agent, false, ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	if err != nil {
+		return agent, false, ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+	return agent, true, nil
+}
+
+// agentRunError answers a request about a run the agent cannot report on.
+func agentRunError(ctx *fiber.Ctx, err error) error {
+	status := fiber.StatusBadRequest
+	switch {
+	case errors.Is(err, worker.ErrNotLeased), errors.Is(err, worker.ErrRunDropped):
+		status = fiber.StatusConflict
+	}
+	return ctx.Status(status).JSON(fiber.Map{
+		"success": false,
+		"error":   err.Error(),
+	})
+}
+
+// RegisterAgent registers a worker agent and returns it with the ID it
+// uses from then on.
+func RegisterAgent(db *gorm.DB) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		agent := new(models.Agent)
+		if err := ctx.BodyParser(agent); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Cannot parse JSON: " + err.Error(),
+			})
+		}
+
+		if err := worker.RegisterAgent(db, agent); err != nil {
+			logger.L.Error("Failed to register agent", "name", agent.Name, "error", err)
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+
+		return ctx.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"success": true,
+			"data":    agent,
+		})
+	}
+}
+
+// AgentHeartbeat keeps an agent and the leases of its runs alive, and tells
+// it which of its runs have been cancelled.
+func AgentHeartbeat(db *gorm.DB) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		agent, ok, err := findAgent(db, ctx)
+		if !ok {
+			return err
+		}
+
+		cancelled, err := worker.AgentHeartbeat(db, agent)
+		if err != nil {
+			logger.L.Error("Failed to record agent heartbeat", "agent_id", agent.ID, "error", err)
+			return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Database error",
+			})
+		}
+
+		return ctx.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success": true,
+			"cancel":  cancelled,
+		})
+	}
+}
+
+// LeaseRun waits up to ?wait (default 30s, at most 1m) for a run from the
+// agent's queues. It returns the leased queue item, or 204 No Content if no
+// run became available.
+func LeaseRun(db *gorm.DB) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		agent, ok, err := findAgent(db, ctx)
+		if !ok {
+			return err
+		}
+
+		wait := defaultLeaseWait
+		if value := ctx.Query("wait"); value != "" {
+			wait, err = time.ParseDuration(value)
+			if err != nil || wait < 0 || wait > worker.MaxLeaseWait {
+				return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"success": false,
+					"error":   fmt.Sprintf("invalid wait %q: must be a duration between 0s and %s", value, worker.MaxLeaseWait),
+				})
+			}
+		}
+
+		item, ok := worker.LeaseForAgent(db, agent, wait)
+		if !ok {
+			return ctx.SendStatus(fiber.StatusNoContent)
+		}
+		return ctx.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success": true,
+			"data":    item,
+		})
+	}
+}
+
+// StartAgentRun starts a leased run and returns the job, secrets and
+// timeout the agent executes it with. It returns 409 if the run is not to
+// be executed after all.
+func StartAgentRun(db *gorm.DB) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		agent, ok, err := findAgent(db, ctx)
+		if !ok {
+			return err
+		}
+		executionID, err := ctx.ParamsInt("executionId")
+		if err != nil || executionID <= 0 {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Invalid execution id",
+			})
+		}
+		req := new(structs.AgentStartRequest)
+		if len(ctx.Body()) > 0 {
+			if err := ctx.BodyParser(req); err != nil {
+				return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"success": false,
+					"error":   "Cannot parse JSON: " + err.Error(),
+				})
+			}
+		}
+
+		assignment, err := worker.StartAgentRun(db, agent, uint(executionID), req.WorkerID)
+		if err != nil {
+			return agentRunError(ctx, err)
+		}
+		return ctx.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success": true,
+			"data":    assignment,
+		})
+	}
+}
+
+// AgentOutput takes output of a running run. The response says whether the
+// run has been cancelled in the meantime.
+func AgentOutput(db *gorm.DB) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		agent, ok, err := findAgent(db, ctx)
+		if !ok {
+			return err
+		}
+		executionID, err := ctx.ParamsInt("executionId")
+		if err != nil || executionID <= 0 {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Invalid execution id",
+			})
+		}
+		req := new(structs.AgentOutputRequest)
+		if err := ctx.BodyParser(req); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Cannot parse JSON: " + err.Error(),
+			})
+		}
+
+		cancel, err := worker.AgentOutput(agent, uint(executionID), req.Chunks)
+		if errors.Is(err, worker.ErrNotLeased) {
+			// Another instance may have started the run.
+			if forwarded, err := forwardToHolder(db, ctx, uint(executionID)); forwarded {
+				return err
+			}
+		}
+		if err != nil {
+			return agentRunError(ctx, err)
+		}
+		return ctx.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success": true,
+			"cancel":  cancel,
+		})
+	}
+}
+
+// AgentResult records how a run executed by an agent ended.
+func AgentResult(db *gorm.DB) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		agent, ok, err := findAgent(db, ctx)
+		if !ok {
+			return err
+		}
+		executionID, err := ctx.ParamsInt("executionId")
+		if err != nil || executionID <= 0 {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Invalid execution id",
+			})
+		}
+		result := new(worker.AgentResult)
+		if err := ctx.BodyParser(result); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Cannot parse JSON: " + err.Error(),
+			})
+		}
+
+		execution, err := worker.FinishAgentRun(db, agent, uint(executionID), *result)
+		if errors.Is(err, worker.ErrNotLeased) {
+			// Another instance may have started the run.
+			if forwarded, err := forwardToHolder(db, ctx, uint(executionID)); forwarded {
+				return err
+			}
+		}
+		if err != nil {
+			return agentRunError(ctx, err)
+		}
+		return ctx.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success": true,
+			"data":    execution,
+		})
+	}
+}
+
+// ListAgents lists the registered worker agents with whether they are
+// online and how many runs each is executing.
+func ListAgents(db *gorm.DB) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		agents, err := worker.ListAgents(db)
+		if err != nil {
+			logger.L.Error("Failed to list agents", "error", err)
+			return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Database error",
+			})
+		}
+		return ctx.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success": true,
+			"data":    agents,
+		})
+	}
+}
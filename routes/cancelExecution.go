@@ -0,0 +1,83 @@
+package routes
+
+import (
+	"errors"
+	"jobScheduler/handlers"
+	"jobScheduler/models"
+	"jobScheduler/worker"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// CancelExecution stops a running execution. The process group is
+// terminated the same way as on a timeout, and the execution is recorded as
+// cancelled once it has exited. A queued execution is cancelled before it
+// starts. An execution running on another instance is cancelled there.
+func CancelExecution(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		auth_ctx := c.Locals("auth_ctx").(handlers.AuthContext)
+
+		executionID, err := c.ParamsInt("id")
+		if err != nil || executionID <= 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Invalid execution id",
+			})
+		}
+
+		var execution models.JobExecution
+		if err := db.First(&execution, executionID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"success": false,
+					"error":   "Execution not found",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Database error",
+			})
+		}
+
+		if execution.Status == models.ExecutionStatusQueued {
+			err := worker.CancelQueued(db, execution.ID, auth_ctx.Username)
+			if err == nil {
+				return c.Status(fiber.StatusOK).JSON(fiber.Map{
+					"success": true,
+					"message": "Queued execution cancelled",
+				})
+			}
+			if !errors.Is(err, worker.ErrExecutionNotRunning) {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"success": false,
+					"error":   err.Error(),
+				})
+			}
+			// It has started in the meantime.
+		}
+
+		if err := worker.CancelExecution(execution.ID, auth_ctx.Username); err != nil {
+			if errors.Is(err, worker.ErrExecutionNotRunning) {
+				// Another instance may be running it.
+				if forwarded, err := forwardToHolder(db, c, execution.ID); forwarded {
+					return err
+				}
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+					"success": false,
+					"error":   "Execution is not running",
+					"status":  execution.Status,
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+			"success": true,
+			"message": "Cancellation requested",
+		})
+	}
+}
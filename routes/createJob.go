@@ -5,6 +5,9 @@ import (
 	"jobScheduler/handlers"
 	"jobScheduler/logger"
 	"jobScheduler/models"
+	"jobScheduler/scheduler"
+	"jobScheduler/secrets"
+	"jobScheduler/worker"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -24,15 +27,15 @@ func CreateJob(db *gorm.DB) fiber.Handler {
 			})
 		}
 
-		if newJob.Name == "" || newJob.Command == "" {
-			logger.L.Error("Missing required fields: name, command")
+		if newJob.Name == "" {
+			logger.L.Error("Missing required fields: name")
 			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"success": false,
-				"error":   "Missing required fields: name, command",
+				"error":   "Missing required fields: name",
 			})
 		}
 
-		if err := newJob.Schedule.Validate(); err != nil {
+		if err := worker.ValidateJobSpec(*newJob); err != nil {
 			logger.L.Error(err.Error())
 			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"success": false,
@@ -40,9 +43,108 @@ func CreateJob(db *gorm.DB) fiber.Handler {
 			})
 		}
 
-		newJob.Status = "pending"
+		if err := secrets.CheckJobSecrets(db, auth_ctx.UserID, newJob.Secrets); err != nil {
+			logger.L.Error("Invalid job secrets", "error", err)
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+
+		if newJob.TimeoutSeconds < 0 {
+			logger.L.Error("Invalid timeout", "timeout_seconds", newJob.TimeoutSeconds)
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "timeoutSeconds must not be negative",
+			})
+		}
+
+		if err := scheduler.ValidateJobSchedule(*newJob); err != nil {
+			logger.L.Error(err.Error())
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+
+		if newJob.Status == "" {
+			newJob.Status = models.JobStatusEnabled
+		}
+		if !models.IsSettableJobStatus(newJob.Status) {
+			logger.L.Error("Invalid job status", "status", newJob.Status)
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Invalid status: must be enabled, paused or disabled",
+			})
+		}
+
+		if newJob.MisfirePolicy == "" {
+			newJob.MisfirePolicy = models.MisfirePolicySkip
+		}
+		if !models.IsValidMisfirePolicy(newJob.MisfirePolicy) || newJob.MisfireLimit < 0 {
+			logger.L.Error("Invalid misfire policy", "policy", newJob.MisfirePolicy, "limit", newJob.MisfireLimit)
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Invalid misfire policy: must be skip, run_once or run_all with a non-negative misfireLimit",
+			})
+		}
+
+		if newJob.ConcurrencyPolicy == "" {
+			newJob.ConcurrencyPolicy = models.ConcurrencyPolicyAllow
+		}
+		if !models.IsValidConcurrencyPolicy(newJob.ConcurrencyPolicy) {
+			logger.L.Error("Invalid concurrency policy", "policy", newJob.ConcurrencyPolicy)
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Invalid concurrency policy: must be allow, forbid, replace or queue",
+			})
+		}
+
+		if err := newJob.Retry.Validate(); err != nil {
+			logger.L.Error("Invalid retry policy", "error", err)
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+
+		if newJob.Queue == "" {
+			newJob.Queue = models.DefaultQueue
+		}
+		if err := worker.ValidateQueue(newJob.Queue); err != nil {
+			logger.L.Error("Invalid queue", "error", err)
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+
+		if err := worker.ValidateNodeSelector(newJob.NodeSelector); err != nil {
+			logger.L.Error("Invalid node selector", "error", err)
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+
 		newJob.CreatedAt = time.Now()
 		newJob.UserID = auth_ctx.UserID
+		newJob.LastStatus = ""
+		newJob.NextRunAt = nil
+		newJob.LastScheduledAt = &newJob.CreatedAt
+
+		if newJob.Status == models.JobStatusEnabled {
+			next, ok := scheduler.NextRun(*newJob, newJob.CreatedAt)
+			if !ok {
+				logger.L.Error("Schedule has no upcoming run times")
+				return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"success": false,
+					"error":   "Schedule has no upcoming run times",
+				})
+			}
+			next = scheduler.WithJitter(newJob.Schedule, next)
+			newJob.NextRunAt = &next
+		}
 
 		result := db.Create(&newJob)
 		if result.Error != nil {
@@ -53,6 +155,8 @@ func CreateJob(db *gorm.DB) fiber.Handler {
 			})
 		}
 
+		worker.ScheduleJob(*newJob)
+
 		message := fmt.Sprintf("created new job with id: %d", newJob.ID)
 		logger.L.Info(message)
 
@@ -6,6 +6,7 @@ import (
 	"gorm.io/gorm"
 	"jobScheduler/logger"
 	"jobScheduler/models"
+	"jobScheduler/worker"
 )
 
 func DeleteJob(db *gorm.DB) fiber.Handler {
@@ -24,7 +25,7 @@ func DeleteJob(db *gorm.DB) fiber.Handler {
 
 		// Check for any database errors during the delete operation.
 		if result.Error != nil {
-			errorMessage := fmt.Sprintf("Delete job with id: %d", result.Error.Error())
+			errorMessage := fmt.Sprintf("Delete job with id: %d: %s", id, result.Error.Error())
 			logger.L.Error(errorMessage)
 			return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"success": false,
@@ -42,6 +43,8 @@ func DeleteJob(db *gorm.DB) fiber.Handler {
 			})
 		}
 
+		worker.UnscheduleJob(uint(id))
+
 		message := fmt.Sprintf("deleted the job with id: %d", id)
 		logger.L.Info(message)
 
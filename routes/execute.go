@@ -5,6 +5,7 @@ import (
 	"jobScheduler/handlers"
 	"jobScheduler/logger"
 	"jobScheduler/models"
+	"jobScheduler/secrets"
 	"jobScheduler/worker"
 
 	"time"
@@ -13,10 +14,57 @@ import (
 	"gorm.io/gorm"
 )
 
+// maxWait limits how long a request may wait for a run with ?wait.
+const maxWait = 5 * time.Minute
+
+// parseWait reads the ?wait duration of a request that starts a run.
+func parseWait(ctx *fiber.Ctx) (time.Duration, error) {
+	value := ctx.Query("wait")
+	if value == "" {
+		return 0, nil
+	}
+	wait, err := time.ParseDuration(value)
+	if err != nil || wait < 0 || wait > maxWait {
+		return 0, fmt.Errorf("invalid wait %q: must be a duration between 0s and %s", value, maxWait)
+	}
+	return wait, nil
+}
+
+// runStarted answers a request that queued a run: 202 Accepted with the
+// queued execution, or, if the caller asked to wait and the execution
+// finished in time, 200 with its outcome.
+func runStarted(ctx *fiber.Ctx, db *gorm.DB, job *models.Job, execution models.JobExecution, wait time.Duration) error {
+	status := fiber.StatusAccepted
+	if wait > 0 && worker.WaitExecution(db, execution.ID, wait) {
+		status = fiber.StatusOK
+	}
+	db.First(&execution, execution.ID)
+	// A failed run may be waiting for a retry, so report the stored LastStatus.
+	db.First(job, job.ID)
+
+	return ctx.Status(status).JSON(fiber.Map{
+		"success":   true,
+		"job":       job,
+		"execution": execution,
+		"statusUrl": fmt.Sprintf("/api/execution/%d", execution.ID),
+	})
+}
+
+// Execute creates an ad-hoc job and queues a single run of it. It returns
+// 202 Accepted with the queued execution right away, unless ?wait=30s is
+// given, in which case it waits up to that long for the run to finish.
 func Execute(db *gorm.DB) fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
 		auth_ctx := ctx.Locals("auth_ctx").(handlers.AuthContext)
 
+		wait, err := parseWait(ctx)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+
 		newJob := new(models.Job)
 
 		if err := ctx.BodyParser(newJob); err != nil {
@@ -27,15 +75,70 @@ func Execute(db *gorm.DB) fiber.Handler {
 			})
 		}
 
-		if newJob.Name == "" || newJob.Command == "" {
-			logger.L.Error("Missing required fields: name, command")
+		if newJob.Name == "" {
+			logger.L.Error("Missing required fields: name")
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Missing required fields: name",
+			})
+		}
+
+		if err := worker.ValidateJobSpec(*newJob); err != nil {
+			logger.L.Error(err.Error())
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+
+		if err := secrets.CheckJobSecrets(db, auth_ctx.UserID, newJob.Secrets); err != nil {
+			logger.L.Error("Invalid job secrets", "error", err)
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+
+		if newJob.TimeoutSeconds < 0 {
+			logger.L.Error("Invalid timeout", "timeout_seconds", newJob.TimeoutSeconds)
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "timeoutSeconds must not be negative",
+			})
+		}
+
+		if err := newJob.Retry.Validate(); err != nil {
+			logger.L.Error("Invalid retry policy", "error", err)
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+
+		if newJob.Queue == "" {
+			newJob.Queue = models.DefaultQueue
+		}
+		if err := worker.ValidateQueue(newJob.Queue); err != nil {
+			logger.L.Error("Invalid queue", "error", err)
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+
+		if err := worker.ValidateNodeSelector(newJob.NodeSelector); err != nil {
+			logger.L.Error("Invalid node selector", "error", err)
 			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"success": false,
-				"error":   "Missing required fields: name, command",
+				"error":   err.Error(),
 			})
 		}
 
-		newJob.Status = "running"
+		// Ad-hoc jobs run once right now and are never picked up by the scheduler.
+		newJob.Status = models.JobStatusDisabled
+		newJob.LastStatus = models.ExecutionStatusQueued
+		newJob.LastRunAt = nil
+		newJob.NextRunAt = nil
 		newJob.UserID = auth_ctx.UserID
 
 		result := db.Create(&newJob)
@@ -50,32 +153,20 @@ func Execute(db *gorm.DB) fiber.Handler {
 		message := fmt.Sprintf("created new job with id: %d", newJob.ID)
 		logger.L.Info(message)
 
-		output, err := worker.ExecuteCommand(newJob.Command)
-		executionStatus := "succeeded"
-		if err != nil {
-			executionStatus = "failed"
-			logger.L.Error("Job execution failed", "job_id", newJob.ID, "error", err, "output", output)
-		} else {
-			logger.L.Info("Job execution succeeded", "job_id", newJob.ID, "output", output)
-		}
-
-		db.Model(&newJob).Update("status", executionStatus)
-
-		// Create the detailed execution record
-		executionRecord := models.JobExecution{
-			JobID:      newJob.ID,
-			Status:     executionStatus,
-			Output:     output,
-			FinishedAt: time.Now(),
+		trigger := models.TriggerManual
+		if auth_ctx.ViaAPIKey {
+			trigger = models.TriggerAPI
 		}
-		if result := db.Create(&executionRecord); result.Error != nil {
-			logger.L.Error("Failed to save job execution history", "job_id", newJob.ID, "error", result.Error)
+		execution, err := worker.Enqueue(db, worker.Run{Job: *newJob, Attempt: 1, Trigger: trigger})
+		if err != nil {
+			logger.L.Error("Failed to queue job", "job_id", newJob.ID, "error", err)
+			return ctx.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+				"success":   false,
+				"error":     "Failed to queue job: " + err.Error(),
+				"execution": execution,
+			})
 		}
 
-		return ctx.Status(fiber.StatusOK).JSON(fiber.Map{
-			"success": true,
-			"job":     newJob,
-		})
-
+		return runStarted(ctx, db, newJob, execution, wait)
 	}
 }
@@ -0,0 +1,42 @@
+package routes
+
+import (
+	"errors"
+	"jobScheduler/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// GetExecution returns a single execution, e.g. to poll a queued run for
+// its outcome.
+func GetExecution(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		executionID, err := c.ParamsInt("id")
+		if err != nil || executionID <= 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Invalid execution id",
+			})
+		}
+
+		var execution models.JobExecution
+		if err := db.First(&execution, executionID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"success": false,
+					"error":   "Execution not found",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Database error",
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success": true,
+			"data":    execution,
+		})
+	}
+}
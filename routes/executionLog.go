@@ -0,0 +1,81 @@
+package routes
+
+import (
+	"errors"
+	"jobScheduler/models"
+	"jobScheduler/worker"
+	"os"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// ExecutionLog returns the full output of an execution from its log file as
+// plain text: ?offset=N&limit=N for a byte range, or ?tail=N for the last N
+// bytes. The X-Log-Offset and X-Log-Size headers give the position of the
+// returned bytes and the size of the whole log.
+func ExecutionLog(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		executionID, err := c.ParamsInt("id")
+		if err != nil || executionID <= 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Invalid execution id",
+			})
+		}
+
+		offset := c.QueryInt("offset", 0)
+		limit := c.QueryInt("limit", worker.MaxLogRead)
+		tail := c.QueryInt("tail", 0)
+		if offset < 0 || limit < 0 || tail < 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "offset, limit and tail must not be negative",
+			})
+		}
+
+		var execution models.JobExecution
+		if err := db.First(&execution, executionID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"success": false,
+					"error":   "Execution not found",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Database error",
+			})
+		}
+		if execution.LogFile == "" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"success": false,
+				"error":   "Execution has no log",
+			})
+		}
+
+		chunk, err := worker.ReadLog(execution.LogFile, int64(offset), int64(limit), int64(tail))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				// The log is kept by the instance that ran the execution.
+				if forwarded, err := forwardToHolder(db, c, execution.ID); forwarded {
+					return err
+				}
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"success": false,
+					"error":   "Execution has no log",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Failed to read log: " + err.Error(),
+			})
+		}
+
+		c.Set("X-Log-Offset", strconv.FormatInt(chunk.Offset, 10))
+		c.Set("X-Log-Size", strconv.FormatInt(chunk.Size, 10))
+		c.Set(fiber.HeaderContentType, fiber.MIMETextPlainCharsetUTF8)
+		return c.Status(fiber.StatusOK).Send(chunk.Data)
+	}
+}
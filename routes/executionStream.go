@@ -0,0 +1,353 @@
+package routes
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"jobScheduler/logger"
+	"jobScheduler/models"
+	"jobScheduler/worker"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// streamPingInterval keeps idle streams open and notices clients that have
+// gone away.
+const streamPingInterval = 15 * time.Second
+
+// startupWait is how long a stream waits for an execution that is recorded
+// as running but has not started producing output yet. Queued executions are
+// waited for until they start.
+const startupWait = 2 * time.Second
+
+// logSink receives the lines of an execution stream.
+type logSink interface {
+	Line(line worker.LogLine) error
+	Ping() error
+}
+
+// streamEnd is sent once an execution's output is complete.
+type streamEnd struct {
+	Status     string    `json:"status"`
+	ExitCode   *int      `json:"exitCode,omitempty"`
+	Signal     string    `json:"signal,omitempty"`
+	DurationMs int64     `json:"durationMs"`
+	FinishedAt time.Time `json:"finishedAt"`
+}
+
+// followExecution sends the output of an execution to sink, starting after
+// the line numbered after: first the lines that are buffered, then each new
+// line until the execution finishes. A queued execution is waited for. The
+// output of an execution that has finished is replayed from its log file.
+// An execution that another instance runs, or whose log that instance
+// keeps, is followed there with the client's credentials, unless
+// credentials is nil. It returns the execution as it was stored at the end,
+// or the error of the sink once the client is gone.
+func followExecution(db *gorm.DB, executionID uint, after int, sink logSink, credentials http.Header) (models.JobExecution, error) {
+	var execution models.JobExecution
+	followed := false
+	deadline := time.Now().Add(startupWait)
+	lastPing := time.Now()
+
+	for {
+		replay, sub, ok := worker.SubscribeLog(executionID, after)
+		if !ok {
+			if err := db.First(&execution, executionID).Error; err != nil {
+				return execution, err
+			}
+			if execution.Status == models.ExecutionStatusQueued {
+				deadline = time.Now().Add(startupWait)
+			} else if !followed && credentials != nil && (execution.Status == models.ExecutionStatusRunning || !logExists(execution.LogFile)) {
+				if url, ok := worker.InstanceURL(db, execution.Instance); ok {
+					return followRemote(db, url, executionID, after, sink, credentials)
+				}
+			}
+			if !followed && (execution.Status == models.ExecutionStatusQueued || execution.Status == models.ExecutionStatusRunning && time.Now().Before(deadline)) {
+				if time.Since(lastPing) >= streamPingInterval {
+					if err := sink.Ping(); err != nil {
+						return execution, err
+					}
+					lastPing = time.Now()
+				}
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			break
+		}
+		followed = true
+
+		err := func() error {
+			defer sub.Close()
+			for _, line := range replay {
+				if err := sink.Line(line); err != nil {
+					return err
+				}
+				after = line.Seq
+			}
+			ticker := time.NewTicker(streamPingInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case line, ok := <-sub.Lines:
+					if !ok {
+						return nil
+					}
+					if err := sink.Line(line); err != nil {
+						return err
+					}
+					after = line.Seq
+				case <-ticker.C:
+					if err := sink.Ping(); err != nil {
+						return err
+					}
+				}
+			}
+		}()
+		if err != nil {
+			return execution, err
+		}
+		if !sub.Lagged {
+			// The execution has finished; its record is final now.
+			err := db.First(&execution, executionID).Error
+			return execution, err
+		}
+	}
+
+	// Lines are numbered by their offset in the log, so a subscriber that
+	// fell behind until the execution finished resumes there too.
+	if execution.LogFile == "" {
+		return execution, nil
+	}
+	for {
+		lines, err := worker.ReadLogLines(execution.LogFile, after)
+		if err != nil || len(lines) == 0 {
+			// The log may have been removed by retention; there is nothing to replay.
+			return execution, nil
+		}
+		for _, line := range lines {
+			if err := sink.Line(line); err != nil {
+				return execution, err
+			}
+			after = line.Seq
+		}
+	}
+}
+
+func logExists(path string) bool {
+	if path == "" {
+		return false
+	}
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// followRemote relays the stream of an execution from the instance at url to
+// sink, and returns the execution as stored once that stream has ended.
+func followRemote(db *gorm.DB, url string, executionID uint, after int, sink logSink, credentials http.Header) (models.JobExecution, error) {
+	var execution models.JobExecution
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/execution/%d/stream?after=%d", url, executionID, after), nil)
+	if err != nil {
+		return execution, err
+	}
+	req.Header = credentials.Clone()
+	req.Header.Set(forwardedHeader, worker.InstanceID())
+
+	resp, err := forwardClient.Do(req)
+	if err != nil {
+		logger.L.Error("Failed to follow execution on the instance holding it", "execution_id", executionID, "url", url, "error", err)
+	} else {
+		defer resp.Body.Close()
+		if resp.StatusCode == fiber.StatusOK {
+			if err := relayEvents(resp.Body, sink); err != nil {
+				return execution, err
+			}
+		}
+	}
+	err = db.First(&execution, executionID).Error
+	return execution, err
+}
+
+// relayEvents passes the lines and pings of a stream served by sseSink to
+// sink, up to its end event. It returns the error of the sink, if any.
+func relayEvents(r io.Reader, sink logSink) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	var event, data string
+	for scanner.Scan() {
+		field := scanner.Text()
+		switch {
+		case field == "":
+			if event == "end" {
+				return nil
+			}
+			var line worker.LogLine
+			if data != "" && json.Unmarshal([]byte(data), &line) == nil {
+				if err := sink.Line(line); err != nil {
+					return err
+				}
+			}
+			event, data = "", ""
+		case strings.HasPrefix(field, ":"):
+			if err := sink.Ping(); err != nil {
+				return err
+			}
+		case strings.HasPrefix(field, "event: "):
+			event = strings.TrimPrefix(field, "event: ")
+		case strings.HasPrefix(field, "data: "):
+			data = strings.TrimPrefix(field, "data: ")
+		}
+	}
+	return nil
+}
+
+// streamCredentials returns what the client authenticated with, to follow
+// the execution on another instance, or nil if the request was forwarded
+// there already.
+func streamCredentials(c *fiber.Ctx) http.Header {
+	if c.Get(forwardedHeader) != "" {
+		return nil
+	}
+	credentials := http.Header{}
+	for _, name := range []string{fiber.HeaderCookie, "X-API-Key"} {
+		if value := c.Get(name); value != "" {
+			credentials.Set(name, value)
+		}
+	}
+	return credentials
+}
+
+func newStreamEnd(execution models.JobExecution) streamEnd {
+	return streamEnd{
+		Status:     execution.Status,
+		ExitCode:   execution.ExitCode,
+		Signal:     execution.Signal,
+		DurationMs: execution.DurationMs,
+		FinishedAt: execution.FinishedAt,
+	}
+}
+
+// sseSink writes lines as Server-Sent Events, with the line number as the
+// event ID so that reconnecting clients resume where they left off.
+type sseSink struct {
+	w *bufio.Writer
+}
+
+func (s sseSink) Line(line worker.LogLine) error {
+	data, _ := json.Marshal(line)
+	fmt.Fprintf(s.w, "id: %d\ndata: %s\n\n", line.Seq, data)
+	return s.w.Flush()
+}
+
+func (s sseSink) Ping() error {
+	s.w.WriteString(": ping\n\n")
+	return s.w.Flush()
+}
+
+// wsSink writes lines as JSON messages over a WebSocket.
+type wsSink struct {
+	conn *websocket.Conn
+}
+
+func (s wsSink) Line(line worker.LogLine) error {
+	return s.conn.WriteJSON(struct {
+		Type string `json:"type"`
+		worker.LogLine
+	}{"line", line})
+}
+
+func (s wsSink) Ping() error {
+	return s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second))
+}
+
+// StreamExecution streams the output of an execution line by line, replaying
+// what is buffered and then following it until the execution finishes. It
+// serves Server-Sent Events: one "message" event per line, and an "end"
+// event with the final status. A WebSocket upgrade request gets the same as
+// JSON messages of type "line" and "end". ?after=N, or the Last-Event-ID
+// header, skips the lines up to N.
+func StreamExecution(db *gorm.DB) fiber.Handler {
+	ws := websocket.New(func(conn *websocket.Conn) {
+		executionID, _ := strconv.Atoi(conn.Params("id"))
+		after, _ := strconv.Atoi(conn.Query("after"))
+
+		// Reading notices when the client closes the connection.
+		go func() {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					conn.Close()
+					return
+				}
+			}
+		}()
+
+		credentials, _ := conn.Locals("stream_credentials").(http.Header)
+		execution, err := followExecution(db, uint(executionID), after, wsSink{conn}, credentials)
+		if err != nil {
+			return
+		}
+		conn.WriteJSON(struct {
+			Type string `json:"type"`
+			streamEnd
+		}{"end", newStreamEnd(execution)})
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	})
+
+	return func(c *fiber.Ctx) error {
+		executionID, err := c.ParamsInt("id")
+		if err != nil || executionID <= 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Invalid execution id",
+			})
+		}
+		after := c.QueryInt("after", 0)
+		if lastID := c.Get("Last-Event-ID"); lastID != "" {
+			after, _ = strconv.Atoi(lastID)
+		}
+
+		var count int64
+		db.Model(&models.JobExecution{}).Where("id = ?", executionID).Count(&count)
+		if count == 0 {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"success": false,
+				"error":   "Execution not found",
+			})
+		}
+
+		if websocket.IsWebSocketUpgrade(c) {
+			// Sessions are cookies, so only accept pages of this server.
+			if origin := c.Get(fiber.HeaderOrigin); origin != "" && origin != c.BaseURL() {
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+					"success": false,
+					"error":   "Cross-origin WebSocket requests are not allowed",
+				})
+			}
+			c.Locals("stream_credentials", streamCredentials(c))
+			return ws(c)
+		}
+
+		c.Set(fiber.HeaderContentType, "text/event-stream")
+		c.Set(fiber.HeaderCacheControl, "no-cache")
+		c.Set(fiber.HeaderConnection, "keep-alive")
+		c.Set("X-Accel-Buffering", "no")
+		credentials := streamCredentials(c)
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			execution, err := followExecution(db, uint(executionID), after, sseSink{w}, credentials)
+			if err != nil {
+				return
+			}
+			data, _ := json.Marshal(newStreamEnd(execution))
+			fmt.Fprintf(w, "event: end\ndata: %s\n\n", data)
+			w.Flush()
+		})
+		return nil
+	}
+}
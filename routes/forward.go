@@ -0,0 +1,93 @@
+package routes
+
+import (
+	"bufio"
+	"bytes"
+	"jobScheduler/logger"
+	"jobScheduler/models"
+	"jobScheduler/worker"
+	"net/http"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// forwardedHeader marks requests forwarded by another instance. They are
+// never forwarded again.
+const forwardedHeader = "X-Forwarded-Instance"
+
+// forwardClient sends forwarded requests. It has no timeout, since a
+// forwarded log stream lasts as long as its execution.
+var forwardClient = &http.Client{}
+
+// holderURL returns the URL of the other instance that holds an execution:
+// the one that runs it, or relays it to its agent, and keeps its log. It
+// returns false if the execution is held by this instance, by one that is
+// gone, or if the request was forwarded already.
+func holderURL(db *gorm.DB, c *fiber.Ctx, executionID uint) (string, bool) {
+	if c.Get(forwardedHeader) != "" {
+		return "", false
+	}
+	var execution models.JobExecution
+	result := db.Select("id", "instance").Where("id = ?", executionID).Limit(1).Find(&execution)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return "", false
+	}
+	return worker.InstanceURL(db, execution.Instance)
+}
+
+// forwardToHolder passes the request on to the other instance that holds the
+// execution, if there is one, and relays its response as it arrives. It
+// reports whether it did.
+func forwardToHolder(db *gorm.DB, c *fiber.Ctx, executionID uint) (bool, error) {
+	url, ok := holderURL(db, c, executionID)
+	if !ok {
+		return false, nil
+	}
+
+	req, err := http.NewRequest(c.Method(), url+c.OriginalURL(), bytes.NewReader(c.Body()))
+	if err != nil {
+		return true, err
+	}
+	c.Request().Header.VisitAll(func(key, value []byte) {
+		req.Header.Add(string(key), string(value))
+	})
+	req.Header.Set(forwardedHeader, worker.InstanceID())
+
+	resp, err := forwardClient.Do(req)
+	if err != nil {
+		logger.L.Error("Failed to forward request to the instance holding the execution", "execution_id", executionID, "url", url, "error", err)
+		return true, c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"success": false,
+			"error":   "The instance holding the execution cannot be reached",
+		})
+	}
+
+	c.Status(resp.StatusCode)
+	for key, values := range resp.Header {
+		if strings.EqualFold(key, fiber.HeaderContentLength) || strings.EqualFold(key, fiber.HeaderTransferEncoding) {
+			continue
+		}
+		for _, value := range values {
+			c.Response().Header.Add(key, value)
+		}
+	}
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer resp.Body.Close()
+		buf := make([]byte, 32<<10)
+		for {
+			n, err := resp.Body.Read(buf)
+			if n > 0 {
+				w.Write(buf[:n])
+				if w.Flush() != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	})
+	return true, nil
+}
@@ -0,0 +1,29 @@
+package routes
+
+import (
+	"jobScheduler/logger"
+	"jobScheduler/worker"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// GetLeader shows which instance runs the scheduling loop, and whether it is
+// the one answering.
+func GetLeader(db *gorm.DB) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		status, err := worker.GetLeaderStatus(db)
+		if err != nil {
+			logger.L.Error("Failed to read the scheduler leader lease", "error", err)
+			return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Failed to read the leader: " + err.Error(),
+			})
+		}
+
+		return ctx.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success": true,
+			"data":    status,
+		})
+	}
+}
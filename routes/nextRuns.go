@@ -0,0 +1,61 @@
+package routes
+
+import (
+	"errors"
+	"jobScheduler/models"
+	"jobScheduler/scheduler"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPreviewCount = 5
+	maxPreviewCount     = 100
+)
+
+// previewCount reads the number of fire times requested through ?count=N.
+func previewCount(c *fiber.Ctx) int {
+	count := c.QueryInt("count", defaultPreviewCount)
+	if count < 1 {
+		count = defaultPreviewCount
+	}
+	if count > maxPreviewCount {
+		count = maxPreviewCount
+	}
+	return count
+}
+
+// ListNextRuns returns the upcoming fire times of an existing job.
+func ListNextRuns(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		jobID := c.Params("id")
+
+		var job models.Job
+		if err := db.First(&job, jobID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"success": false,
+					"error":   "Job not found",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Database error",
+			})
+		}
+
+		// A job that is not enabled will not fire, whatever its schedule says.
+		runs := []time.Time{}
+		if job.Status == models.JobStatusEnabled {
+			runs = scheduler.NextRuns(job, time.Now(), previewCount(c))
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success": true,
+			"status":  job.Status,
+			"data":    runs,
+		})
+	}
+}
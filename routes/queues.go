@@ -0,0 +1,29 @@
+package routes
+
+import (
+	"jobScheduler/logger"
+	"jobScheduler/worker"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// ListQueues lists the configured job queues with their workers, capacity
+// and how many runs are waiting in and leased from each.
+func ListQueues(db *gorm.DB) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		stats, err := worker.QueueStats(db)
+		if err != nil {
+			logger.L.Error("Failed to read queue stats", "error", err)
+			return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Failed to read queues: " + err.Error(),
+			})
+		}
+
+		return ctx.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success": true,
+			"data":    stats,
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package routes
+
+import (
+	"errors"
+	"jobScheduler/handlers"
+	"jobScheduler/logger"
+	"jobScheduler/models"
+	"jobScheduler/structs"
+	"jobScheduler/worker"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// RunJob queues a run of an existing job right away, without changing its
+// schedule. The optional params are filled into a templated Command and
+// recorded on the execution. Like Execute, it returns 202 Accepted with the
+// queued execution unless ?wait is given.
+func RunJob(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		auth_ctx := c.Locals("auth_ctx").(handlers.AuthContext)
+
+		wait, err := parseWait(c)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+
+		req := new(structs.RunJobRequest)
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(req); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"success": false,
+					"error":   "Cannot parse JSON",
+				})
+			}
+		}
+
+		var job models.Job
+		if err := db.First(&job, c.Params("id")).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"success": false,
+					"error":   "Job not found",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Database error",
+			})
+		}
+
+		// The run gets the owner's secrets, so nobody else may start it with
+		// params of their choosing.
+		user, err := currentUser(db, c)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Database error",
+			})
+		}
+		if !actsAsOwner(user, job) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"success": false,
+				"error":   "Only the owner of the job or an admin may run it",
+			})
+		}
+
+		// Catch missing parameters now rather than in a failed execution.
+		if _, err := worker.RenderCommand(job, req.Params, time.Now()); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+
+		trigger := models.TriggerManual
+		if auth_ctx.ViaAPIKey {
+			trigger = models.TriggerAPI
+		}
+		execution, err := worker.Enqueue(db, worker.Run{Job: job, Attempt: 1, Trigger: trigger, Params: req.Params})
+		if err != nil {
+			logger.L.Error("Failed to queue job", "job_id", job.ID, "error", err)
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+				"success":   false,
+				"error":     "Failed to queue job: " + err.Error(),
+				"execution": execution,
+			})
+		}
+
+		logger.L.Info("Job run requested", "job_id", job.ID, "execution_id", execution.ID, "user", auth_ctx.Username)
+
+		return runStarted(c, db, &job, execution, wait)
+	}
+}
@@ -0,0 +1,37 @@
+package routes
+
+import (
+	"jobScheduler/models"
+	"jobScheduler/scheduler"
+	"jobScheduler/structs"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// PreviewSchedule validates a schedule or cron expression without saving
+// anything and returns the times it would fire at.
+func PreviewSchedule() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		req := new(structs.SchedulePreviewRequest)
+		if err := c.BodyParser(req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Cannot parse JSON: " + err.Error(),
+			})
+		}
+
+		job := models.Job{Schedule: req.Schedule, Cron: req.Cron}
+		if err := scheduler.ValidateJobSchedule(job); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success": true,
+			"data":    scheduler.NextRuns(job, time.Now(), previewCount(c)),
+		})
+	}
+}
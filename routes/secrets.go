@@ -0,0 +1,253 @@
+package routes
+
+import (
+	"errors"
+	"jobScheduler/handlers"
+	"jobScheduler/logger"
+	"jobScheduler/models"
+	"jobScheduler/secrets"
+	"jobScheduler/structs"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// currentUser loads the authenticated user, who is needed for their team.
+func currentUser(db *gorm.DB, ctx *fiber.Ctx) (models.User, error) {
+	auth_ctx := ctx.Locals("auth_ctx").(handlers.AuthContext)
+	var user models.User
+	err := db.First(&user, auth_ctx.UserID).Error
+	return user, err
+}
+
+// canManageSecret reports whether the user may change or delete the secret:
+// their own secrets, their team's, and any team's for admins.
+func canManageSecret(user models.User, secret models.Secret) bool {
+	if secret.Team == "" {
+		return secret.UserID == user.ID
+	}
+	return user.IsAdmin || secret.Team == user.Team
+}
+
+// ListSecrets lists the names and scopes of the secrets the user can use.
+// Values are never returned.
+func ListSecrets(db *gorm.DB) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		user, err := currentUser(db, ctx)
+		if err != nil {
+			return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Database error",
+			})
+		}
+
+		query := secrets.VisibleTo(db, user)
+		if user.IsAdmin {
+			query = db.Where("team <> '' OR user_id = ?", user.ID)
+		}
+		var found []models.Secret
+		if err := query.Order("name").Find(&found).Error; err != nil {
+			return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Database error",
+			})
+		}
+
+		return ctx.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success": true,
+			"data":    found,
+		})
+	}
+}
+
+func CreateSecret(db *gorm.DB) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		if !secrets.Enabled() {
+			return ctx.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+				"success": false,
+				"error":   secrets.ErrNotConfigured.Error(),
+			})
+		}
+
+		user, err := currentUser(db, ctx)
+		if err != nil {
+			return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Database error",
+			})
+		}
+
+		req := new(structs.SecretRequest)
+		if err := ctx.BodyParser(req); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Cannot parse JSON",
+			})
+		}
+		if !models.IsValidEnvName(req.Name) {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Invalid name: must be letters, digits and underscores, not starting with a digit",
+			})
+		}
+		if req.Value == "" {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Missing required field: value",
+			})
+		}
+
+		secret := models.Secret{Name: req.Name, UserID: user.ID, UpdatedBy: user.Username}
+		if req.Team != "" {
+			if !user.IsAdmin && req.Team != user.Team {
+				return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+					"success": false,
+					"error":   "You can only create secrets for your own team",
+				})
+			}
+			secret.UserID = 0
+			secret.Team = req.Team
+		}
+
+		var count int64
+		db.Model(&models.Secret{}).Where("name = ? AND user_id = ? AND team = ?", secret.Name, secret.UserID, secret.Team).Count(&count)
+		if count > 0 {
+			return ctx.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"success": false,
+				"error":   "A secret with this name already exists",
+			})
+		}
+
+		if err := secrets.Seal(&secret, req.Value); err != nil {
+			logger.L.Error("Failed to encrypt secret", "name", secret.Name, "error", err)
+			return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Failed to encrypt secret",
+			})
+		}
+		if err := db.Create(&secret).Error; err != nil {
+			return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Failed to save secret: " + err.Error(),
+			})
+		}
+
+		logger.L.Info("Secret created", "secret_id", secret.ID, "name", secret.Name, "team", secret.Team, "user", user.Username)
+
+		return ctx.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"success": true,
+			"data":    secret,
+		})
+	}
+}
+
+// findManagedSecret loads the secret named by the :id parameter and checks
+// that the user may manage it. On failure the response has been written.
+func findManagedSecret(db *gorm.DB, ctx *fiber.Ctx) (models.Secret, models.User, bool, error) {
+	var secret models.Secret
+
+	user, err := currentUser(db, ctx)
+	if err != nil {
+		return secret, user, false, ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+
+	if err := db.First(&secret, ctx.Params("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return secret, user, false, ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"success": false,
+				"error":   "Secret not found",
+			})
+		}
+		return secret, user, false, ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Database error",
+		})
+	}
+
+	if !canManageSecret(user, secret) {
+		return secret, user, false, ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "Secret not found",
+		})
+	}
+	return secret, user, true, nil
+}
+
+// UpdateSecret replaces the value of a secret.
+func UpdateSecret(db *gorm.DB) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		if !secrets.Enabled() {
+			return ctx.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+				"success": false,
+				"error":   secrets.ErrNotConfigured.Error(),
+			})
+		}
+
+		secret, user, ok, err := findManagedSecret(db, ctx)
+		if !ok {
+			return err
+		}
+
+		req := new(structs.SecretRequest)
+		if err := ctx.BodyParser(req); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Cannot parse JSON",
+			})
+		}
+		if req.Value == "" {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Missing required field: value",
+			})
+		}
+
+		if err := secrets.Seal(&secret, req.Value); err != nil {
+			logger.L.Error("Failed to encrypt secret", "secret_id", secret.ID, "error", err)
+			return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Failed to encrypt secret",
+			})
+		}
+		secret.UpdatedBy = user.Username
+		if err := db.Save(&secret).Error; err != nil {
+			return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Failed to update secret: " + err.Error(),
+			})
+		}
+
+		logger.L.Info("Secret updated", "secret_id", secret.ID, "name", secret.Name, "user", user.Username)
+
+		return ctx.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success": true,
+			"data":    secret,
+		})
+	}
+}
+
+func DeleteSecret(db *gorm.DB) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		secret, user, ok, err := findManagedSecret(db, ctx)
+		if !ok {
+			return err
+		}
+
+		if err := db.Delete(&secret).Error; err != nil {
+			return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"error":   "Failed to delete secret: " + err.Error(),
+			})
+		}
+
+		logger.L.Info("Secret deleted", "secret_id", secret.ID, "name", secret.Name, "user", user.Username)
+
+		return ctx.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success": true,
+			"message": "Secret successfully deleted",
+		})
+	}
+}
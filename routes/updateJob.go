@@ -1,14 +1,49 @@
 package routes
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/gorm"
 	"jobScheduler/logger"
 	"jobScheduler/models"
+	"jobScheduler/scheduler"
+	"jobScheduler/secrets"
+	"jobScheduler/worker"
+	"reflect"
+	"slices"
+	"time"
 )
 
+// actsAsOwner reports whether the user may change what a job executes, or
+// run it, with the secrets of its owner: only the owner and admins may.
+func actsAsOwner(user models.User, job models.Job) bool {
+	return user.IsAdmin || job.UserID == user.ID
+}
+
+// jobUpdateFields maps the JSON names of the fields UpdateJob changes to
+// the names of the Job fields. Run bookkeeping belongs to the scheduler and
+// workers, and the owner and ID cannot change.
+var jobUpdateFields = map[string]string{
+	"name":              "Name",
+	"command":           "Command",
+	"schedule":          "Schedule",
+	"cron":              "Cron",
+	"status":            "Status",
+	"timeoutSeconds":    "TimeoutSeconds",
+	"misfirePolicy":     "MisfirePolicy",
+	"misfireLimit":      "MisfireLimit",
+	"retry":             "Retry",
+	"concurrencyPolicy": "ConcurrencyPolicy",
+	"queue":             "Queue",
+	"priority":          "Priority",
+	"nodeSelector":      "NodeSelector",
+	"type":              "Type",
+	"spec":              "Spec",
+	"secrets":           "Secrets",
+}
+
 func UpdateJob(db *gorm.DB) fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
 		id := ctx.QueryInt("id")
@@ -18,7 +53,7 @@ func UpdateJob(db *gorm.DB) fiber.Handler {
 		if err := db.First(&existingJob, id).Error; err != nil {
 			if errors.Is(err, gorm.ErrRecordNotFound) {
 
-				errorMessage := fmt.Sprintf("Failed to find job with id %s: %v", id, err)
+				errorMessage := fmt.Sprintf("Failed to find job with id %d: %v", id, err)
 
 				logger.L.Error(errorMessage)
 				return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
@@ -33,7 +68,16 @@ func UpdateJob(db *gorm.DB) fiber.Handler {
 			})
 		}
 
+		// Only the fields in the request are changed, including those set to
+		// their zero value, such as an empty cron.
+		var sent map[string]json.RawMessage
 		var updatedData models.Job
+		if err := json.Unmarshal(ctx.Body(), &sent); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Cannot parse JSON: " + err.Error(),
+			})
+		}
 		if err := ctx.BodyParser(&updatedData); err != nil {
 			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"success": false,
@@ -41,14 +85,179 @@ func UpdateJob(db *gorm.DB) fiber.Handler {
 			})
 		}
 
-		result := db.Model(&existingJob).Updates(updatedData)
-		if result.Error != nil {
-			return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		job := existingJob
+		var fields []string
+		update := func(field string, value reflect.Value) {
+			reflect.ValueOf(&job).Elem().FieldByName(field).Set(value)
+			if !slices.Contains(fields, field) {
+				fields = append(fields, field)
+			}
+		}
+		for name := range sent {
+			if field, ok := jobUpdateFields[name]; ok {
+				update(field, reflect.ValueOf(updatedData).FieldByName(field))
+			}
+		}
+		_, sentCron := sent["cron"]
+		_, sentSchedule := sent["schedule"]
+		_, sentSpec := sent["spec"]
+		// A job has either a cron expression or a Schedule, so setting one
+		// drops the other. Cron expressions keep the schedule's time zone.
+		if sentCron && job.Cron != "" && !sentSchedule {
+			update("Schedule", reflect.ValueOf(models.Schedule{Timezone: job.Schedule.Timezone}))
+		}
+		if sentSchedule && !sentCron && (len(job.Schedule.Times) > 0 || job.Schedule.Kind != "") {
+			update("Cron", reflect.ValueOf(""))
+		}
+		// A spec belongs to the executor of one type, so changing the type
+		// without a new spec drops the old one.
+		if job.Type != existingJob.Type && !sentSpec {
+			update("Spec", reflect.ValueOf(models.JobSpec(nil)))
+		}
+
+		// The command, type, spec and secrets decide what the job does with
+		// its owner's secrets, so nobody else may change them.
+		changesExecution := slices.ContainsFunc([]string{"Command", "Type", "Spec", "Secrets"}, func(field string) bool {
+			return slices.Contains(fields, field)
+		})
+		if changesExecution {
+			user, err := currentUser(db, ctx)
+			if err != nil {
+				return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"success": false,
+					"error":   "Database error",
+				})
+			}
+			if !actsAsOwner(user, existingJob) {
+				return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+					"success": false,
+					"error":   "Only the owner of the job or an admin may change its command, type, spec or secrets",
+				})
+			}
+		}
+
+		// The job is checked as it will be saved, not only the fields sent.
+		if job.Name == "" {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Missing required fields: name",
+			})
+		}
+
+		// A completed job keeps its status until a new one is sent.
+		if job.Status == "" {
+			job.Status = models.JobStatusEnabled
+		}
+		if slices.Contains(fields, "Status") && !models.IsSettableJobStatus(job.Status) {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Invalid status: must be enabled, paused or disabled",
+			})
+		}
+
+		if err := scheduler.ValidateJobSchedule(job); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+
+		if job.MisfirePolicy == "" {
+			job.MisfirePolicy = models.MisfirePolicySkip
+		}
+		if !models.IsValidMisfirePolicy(job.MisfirePolicy) || job.MisfireLimit < 0 {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Invalid misfire policy: must be skip, run_once or run_all with a non-negative misfireLimit",
+			})
+		}
+
+		if job.TimeoutSeconds < 0 {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "timeoutSeconds must not be negative",
+			})
+		}
+
+		if err := worker.ValidateJobSpec(job); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"success": false,
-				"error":   "Failed to update job: " + result.Error.Error(),
+				"error":   err.Error(),
 			})
 		}
 
+		if slices.Contains(fields, "Secrets") {
+			if err := secrets.CheckJobSecrets(db, existingJob.UserID, job.Secrets); err != nil {
+				return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"success": false,
+					"error":   err.Error(),
+				})
+			}
+		}
+
+		if job.ConcurrencyPolicy == "" {
+			job.ConcurrencyPolicy = models.ConcurrencyPolicyAllow
+		}
+		if !models.IsValidConcurrencyPolicy(job.ConcurrencyPolicy) {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   "Invalid concurrency policy: must be allow, forbid, replace or queue",
+			})
+		}
+
+		if err := job.Retry.Validate(); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+
+		if job.Queue == "" {
+			job.Queue = models.DefaultQueue
+		}
+		// A queue removed from the configuration only matters once it is sent,
+		// since the job runs in the default queue meanwhile.
+		if err := worker.ValidateQueue(updatedData.Queue); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+
+		if err := worker.ValidateNodeSelector(job.NodeSelector); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+
+		rescheduled := job.Cron != existingJob.Cron || job.Status != existingJob.Status ||
+			!reflect.DeepEqual(job.Schedule, existingJob.Schedule)
+
+		if len(fields) > 0 {
+			if result := db.Model(&existingJob).Select(fields).Updates(&job); result.Error != nil {
+				return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"success": false,
+					"error":   "Failed to update job: " + result.Error.Error(),
+				})
+			}
+		}
+
+		// Arming counts the fire times up to now as handled, so it is only
+		// done when the schedule or status changed. Otherwise fire times still
+		// pending, e.g. while the queue is full, are left to the scheduler and
+		// the job's misfire policy.
+		if rescheduled {
+			var savedJob models.Job
+			if err := db.First(&savedJob, id).Error; err == nil {
+				if err := worker.ArmJob(db, savedJob, time.Now()); err != nil {
+					logger.L.Error("Failed to arm job", "job_id", savedJob.ID, "error", err)
+				}
+			}
+		}
+		existingJob = models.Job{}
+		db.First(&existingJob, id)
+
 		message := fmt.Sprintf("updated the job with id: %d", existingJob.ID)
 		logger.L.Info(message)
 
@@ -0,0 +1,381 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is a parsed cron expression.
+//
+// Both the classic five-field form (minute hour day-of-month month weekday)
+// and a six-field form with a leading seconds field are accepted, as well as
+// the @yearly, @monthly, @weekly, @daily, @midnight and @hourly shortcuts.
+// Fields support lists, ranges, steps and month or weekday names. The
+// day-of-month field additionally understands L (last day), LW (last
+// weekday) and nW (weekday nearest to day n); the weekday field understands
+// nL (last weekday n of the month) and n#k (k-th weekday n of the month).
+//
+// As in Vixie cron, when both day fields are restricted a day matches if
+// either of them does. A day field starting with * or ? counts as
+// unrestricted for that rule, though a step such as */5 still selects days.
+type Cron struct {
+	seconds     uint64
+	minutes     uint64
+	hours       uint64
+	daysOfMonth uint64
+	months      uint64
+	weekdays    uint64
+
+	withSeconds    bool
+	dayOfMonthStar bool // the field starts with * or ?
+	weekdayStar    bool // the field starts with * or ?
+
+	lastDayOfMonth     bool         // L
+	lastWeekdayOfMonth bool         // LW
+	nearestWeekdays    uint64       // nW
+	lastWeekdays       uint64       // nL
+	nthWeekdays        []nthWeekday // n#k
+}
+
+type nthWeekday struct {
+	weekday time.Weekday
+	n       int
+}
+
+type cronField struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	secondField = cronField{name: "second", min: 0, max: 59}
+	minuteField = cronField{name: "minute", min: 0, max: 59}
+	hourField   = cronField{name: "hour", min: 0, max: 23}
+	dayField    = cronField{name: "day of month", min: 1, max: 31}
+	monthField  = cronField{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// Both 0 and 7 mean Sunday.
+	weekdayField = cronField{name: "weekday", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCron parses a five- or six-field cron expression or a shortcut such
+// as @daily.
+func ParseCron(expr string) (*Cron, error) {
+	spec := strings.TrimSpace(expr)
+	if strings.HasPrefix(spec, "@") {
+		descriptor, ok := cronDescriptors[strings.ToLower(spec)]
+		if !ok {
+			return nil, fmt.Errorf("invalid cron expression %q: unknown descriptor", expr)
+		}
+		spec = descriptor
+	}
+
+	fields := strings.Fields(spec)
+	c := &Cron{}
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+		c.withSeconds = true
+	default:
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 or 6 fields, got %d", expr, len(fields))
+	}
+
+	var err error
+	if c.seconds, err = parseCronField(fields[0], secondField); err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q: %w", expr, err)
+	}
+	if c.minutes, err = parseCronField(fields[1], minuteField); err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q: %w", expr, err)
+	}
+	if c.hours, err = parseCronField(fields[2], hourField); err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q: %w", expr, err)
+	}
+	if err = c.parseDaysOfMonth(fields[3]); err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q: %w", expr, err)
+	}
+	if c.months, err = parseCronField(fields[4], monthField); err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q: %w", expr, err)
+	}
+	if err = c.parseWeekdays(fields[5]); err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q: %w", expr, err)
+	}
+
+	return c, nil
+}
+
+// parseDaysOfMonth handles the L, LW and nW modifiers before passing the
+// remaining plain values to parseCronField.
+func (c *Cron) parseDaysOfMonth(field string) error {
+	c.dayOfMonthStar = strings.HasPrefix(field, "*") || field == "?"
+
+	var plain []string
+	for _, part := range strings.Split(field, ",") {
+		upper := strings.ToUpper(part)
+		switch {
+		case upper == "L":
+			c.lastDayOfMonth = true
+		case upper == "LW":
+			c.lastWeekdayOfMonth = true
+		case strings.HasSuffix(upper, "W"):
+			day, err := parseCronValue(strings.TrimSuffix(upper, "W"), dayField)
+			if err != nil {
+				return err
+			}
+			c.nearestWeekdays |= 1 << uint(day)
+		default:
+			plain = append(plain, part)
+		}
+	}
+
+	if len(plain) == 0 {
+		return nil
+	}
+	bits, err := parseCronField(strings.Join(plain, ","), dayField)
+	if err != nil {
+		return err
+	}
+	c.daysOfMonth = bits
+	return nil
+}
+
+// parseWeekdays handles the nL and n#k modifiers before passing the
+// remaining plain values to parseCronField.
+func (c *Cron) parseWeekdays(field string) error {
+	c.weekdayStar = strings.HasPrefix(field, "*") || field == "?"
+
+	var plain []string
+	for _, part := range strings.Split(field, ",") {
+		upper := strings.ToUpper(part)
+		switch {
+		case strings.Contains(upper, "#"):
+			weekday, nth, _ := strings.Cut(upper, "#")
+			day, err := parseCronValue(weekday, weekdayField)
+			if err != nil {
+				return err
+			}
+			n, err := strconv.Atoi(nth)
+			if err != nil || n < 1 || n > 5 {
+				return fmt.Errorf("invalid weekday occurrence %q: must be between 1-5", nth)
+			}
+			c.nthWeekdays = append(c.nthWeekdays, nthWeekday{weekday: time.Weekday(day % 7), n: n})
+		case upper == "L":
+			// A bare L in the weekday field means Saturday, the last day of the week.
+			c.weekdays |= 1 << uint(time.Saturday)
+		case strings.HasSuffix(upper, "L"):
+			day, err := parseCronValue(strings.TrimSuffix(upper, "L"), weekdayField)
+			if err != nil {
+				return err
+			}
+			c.lastWeekdays |= 1 << uint(day%7)
+		default:
+			plain = append(plain, part)
+		}
+	}
+
+	if len(plain) == 0 {
+		return nil
+	}
+	bits, err := parseCronField(strings.Join(plain, ","), weekdayField)
+	if err != nil {
+		return err
+	}
+	// Fold 7 onto 0 so Sunday has a single bit.
+	if bits&(1<<7) != 0 {
+		bits = bits&^(1<<7) | 1
+	}
+	c.weekdays |= bits
+	return nil
+}
+
+// parseCronField turns a comma separated list of values, ranges and steps
+// into a bit set where bit n is set when value n is selected.
+func parseCronField(field string, f cronField) (uint64, error) {
+	if field == "" {
+		return 0, fmt.Errorf("empty %s field", f.name)
+	}
+
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid %s step %q", f.name, stepPart)
+			}
+		}
+
+		var low, high int
+		switch {
+		case rangePart == "*" || rangePart == "?":
+			low, high = f.min, f.max
+		case strings.Contains(rangePart, "-"):
+			from, to, _ := strings.Cut(rangePart, "-")
+			var err error
+			if low, err = parseCronValue(from, f); err != nil {
+				return 0, err
+			}
+			if high, err = parseCronValue(to, f); err != nil {
+				return 0, err
+			}
+			if low > high {
+				return 0, fmt.Errorf("invalid %s range %q: start is after end", f.name, rangePart)
+			}
+		default:
+			var err error
+			if low, err = parseCronValue(rangePart, f); err != nil {
+				return 0, err
+			}
+			high = low
+			// "5/15" means every 15 starting at 5.
+			if hasStep {
+				high = f.max
+			}
+		}
+
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+func parseCronValue(value string, f cronField) (int, error) {
+	if n, ok := f.names[strings.ToLower(value)]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s value %q", f.name, value)
+	}
+	if n < f.min || n > f.max {
+		return 0, fmt.Errorf("invalid %s value: %d, must be between %d-%d", f.name, n, f.min, f.max)
+	}
+	return n, nil
+}
+
+// Next returns the first time strictly after t selected by the expression,
+// reading fields as wall-clock times in t's location. It returns false when
+// nothing matches within the search horizon, which only happens for
+// expressions such as "0 0 30 2 *" that can never fire.
+func (c *Cron) Next(t time.Time) (time.Time, bool) {
+	for i := 0; i <= searchHorizonYears*366; i++ {
+		day := time.Date(t.Year(), t.Month(), t.Day()+i, 12, 0, 0, 0, t.Location())
+		if c.months&(1<<uint(day.Month())) == 0 || !c.matchesDay(day) {
+			continue
+		}
+
+		for hour := 0; hour < 24; hour++ {
+			if c.hours&(1<<uint(hour)) == 0 || (i == 0 && hour < t.Hour()) {
+				continue
+			}
+			for minute := 0; minute < 60; minute++ {
+				if c.minutes&(1<<uint(minute)) == 0 || (i == 0 && hour == t.Hour() && minute < t.Minute()) {
+					continue
+				}
+				for second := 0; second < 60; second++ {
+					if c.seconds&(1<<uint(second)) == 0 {
+						continue
+					}
+					candidate := wallClock(day.Year(), day.Month(), day.Day(), hour, minute, second, t.Location())
+					if candidate.After(t) {
+						return candidate, true
+					}
+				}
+			}
+		}
+	}
+
+	return time.Time{}, false
+}
+
+func (c *Cron) matchesDay(t time.Time) bool {
+	dayOfMonth := c.matchesDayOfMonth(t)
+	weekday := c.matchesWeekday(t)
+	if c.dayOfMonthStar || c.weekdayStar {
+		return dayOfMonth && weekday
+	}
+	return dayOfMonth || weekday
+}
+
+func (c *Cron) matchesDayOfMonth(t time.Time) bool {
+	day := t.Day()
+	if c.daysOfMonth&(1<<uint(day)) != 0 {
+		return true
+	}
+
+	last := daysInMonth(t.Year(), t.Month())
+	if c.lastDayOfMonth && day == last {
+		return true
+	}
+	if c.lastWeekdayOfMonth && day == nearestWeekday(t.Year(), t.Month(), last) {
+		return true
+	}
+	for d := 1; d <= last; d++ {
+		if c.nearestWeekdays&(1<<uint(d)) != 0 && day == nearestWeekday(t.Year(), t.Month(), d) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Cron) matchesWeekday(t time.Time) bool {
+	weekday := t.Weekday()
+	if c.weekdays&(1<<uint(weekday)) != 0 {
+		return true
+	}
+	if c.lastWeekdays&(1<<uint(weekday)) != 0 && t.Day()+7 > daysInMonth(t.Year(), t.Month()) {
+		return true
+	}
+	for _, nth := range c.nthWeekdays {
+		if nth.weekday == weekday && (t.Day()-1)/7+1 == nth.n {
+			return true
+		}
+	}
+
+	return false
+}
+
+func daysInMonth(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 12, 0, 0, 0, time.UTC).Day()
+}
+
+// nearestWeekday returns the Monday-to-Friday day closest to day without
+// leaving the month, following the Quartz meaning of the W modifier.
+func nearestWeekday(year int, month time.Month, day int) int {
+	switch time.Date(year, month, day, 12, 0, 0, 0, time.UTC).Weekday() {
+	case time.Saturday:
+		if day == 1 {
+			return day + 2
+		}
+		return day - 1
+	case time.Sunday:
+		if day == daysInMonth(year, month) {
+			return day - 2
+		}
+		return day + 1
+	}
+	return day
+}
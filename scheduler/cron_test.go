@@ -0,0 +1,211 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{
+			name: "every minute",
+			expr: "* * * * *",
+			from: time.Date(2024, 5, 1, 10, 15, 30, 0, time.UTC),
+			want: time.Date(2024, 5, 1, 10, 16, 0, 0, time.UTC),
+		},
+		{
+			name: "strictly after from",
+			expr: "0 12 * * *",
+			from: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+			want: time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "seconds field",
+			expr: "30 0 0 * * *",
+			from: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2024, 1, 1, 0, 0, 30, 0, time.UTC),
+		},
+		{
+			name: "step from a start value",
+			expr: "5/20 * * * *",
+			from: time.Date(2024, 1, 1, 0, 26, 0, 0, time.UTC),
+			want: time.Date(2024, 1, 1, 0, 45, 0, 0, time.UTC),
+		},
+		{
+			name: "step in the day-of-month field",
+			expr: "0 0 */5 * *",
+			from: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2024, 1, 6, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "step in the day-of-month field skips to the next month",
+			expr: "0 0 */5 * *",
+			from: time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "step in the weekday field",
+			expr: "0 0 * * */2",
+			from: time.Date(2024, 6, 4, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2024, 6, 6, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "a day field starting with * restricts together with the other",
+			expr: "0 0 */10 * 1",
+			from: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "month and weekday names",
+			expr: "0 9 * feb mon",
+			from: time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2024, 2, 5, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "descriptor",
+			expr: "@monthly",
+			from: time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "both day fields restricted match either",
+			expr: "0 0 13 * 5",
+			from: time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2024, 9, 6, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "L is the last day of a leap February",
+			expr: "0 0 L * *",
+			from: time.Date(2024, 2, 10, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "L is the last day of a 30-day month",
+			expr: "0 0 L * *",
+			from: time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2024, 4, 30, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "LW moves back from a Saturday",
+			expr: "0 0 LW * *",
+			from: time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2024, 8, 30, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "W moves a Saturday back to Friday",
+			expr: "0 0 15W * *",
+			from: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2024, 6, 14, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "W does not move a Saturday the 1st into the previous month",
+			expr: "0 0 1W * *",
+			from: time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "W does not move a Sunday the 31st into the next month",
+			expr: "0 0 31W * *",
+			from: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2024, 3, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "W keeps a weekday",
+			expr: "0 0 10W * *",
+			from: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "nL is the last weekday n of the month",
+			expr: "0 0 * * 5L",
+			from: time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2024, 11, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "a bare L in the weekday field is Saturday",
+			expr: "0 0 * * L",
+			from: time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2024, 11, 2, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "# is the k-th weekday n of the month",
+			expr: "0 0 * * 1#2",
+			from: time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2024, 10, 14, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "# moves on to the next month once it has passed",
+			expr: "0 0 * * MON#1",
+			from: time.Date(2024, 9, 3, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2024, 10, 7, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "a fifth weekday skips months without one",
+			expr: "0 0 * * 5#5",
+			from: time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "7 is Sunday",
+			expr: "0 0 * * 7",
+			from: time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2024, 6, 9, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cron, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseCron(%q): %v", tt.expr, err)
+			}
+			got, ok := cron.Next(tt.from)
+			if !ok || !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, %v; want %s", tt.from, got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestCronNextNeverFires(t *testing.T) {
+	cron, err := ParseCron("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := cron.Next(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)); ok {
+		t.Errorf("Next = %s, want no occurrence", got)
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"* * * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"* * 32W * *",
+		"* * * * 1#6",
+		"* * * * 1#x",
+		"* * * * 9L",
+		"@every",
+	}
+
+	for _, expr := range tests {
+		t.Run(expr, func(t *testing.T) {
+			if _, err := ParseCron(expr); err == nil {
+				t.Errorf("ParseCron(%q) succeeded, want an error", expr)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package scheduler
+
+import (
+	"jobScheduler/models"
+	"math/rand/v2"
+	"time"
+)
+
+// nextInterval returns the first time strictly after t on the grid
+// Anchor + k*Interval that lies within the schedule's StartAt and EndAt
+// bounds. Intervals measure elapsed time, so they are not affected by
+// daylight saving transitions.
+//
+// Without an Anchor the grid is aligned to StartAt, or failing that to
+// midnight on 1 January 2000 in the schedule's time zone, so that for
+// example a one-hour interval fires on the hour.
+func nextInterval(schedule models.Schedule, t time.Time) (time.Time, bool) {
+	interval, err := time.ParseDuration(schedule.Interval)
+	if err != nil || interval <= 0 {
+		return time.Time{}, false
+	}
+	loc, err := schedule.Location()
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	var anchor time.Time
+	switch {
+	case schedule.Anchor != nil:
+		anchor = *schedule.Anchor
+	case schedule.StartAt != nil:
+		anchor = *schedule.StartAt
+	default:
+		anchor = time.Date(2000, time.January, 1, 0, 0, 0, 0, loc)
+	}
+
+	// StartAt itself is a valid run time, so search from just before it.
+	if schedule.StartAt != nil && t.Before(*schedule.StartAt) {
+		t = schedule.StartAt.Add(-time.Nanosecond)
+	}
+
+	// Floor division, so that times before the anchor work as well.
+	elapsed := t.Sub(anchor)
+	steps := elapsed / interval
+	if elapsed < 0 && elapsed%interval != 0 {
+		steps--
+	}
+	next := anchor.Add((steps + 1) * interval)
+
+	if schedule.EndAt != nil && next.After(*schedule.EndAt) {
+		return time.Time{}, false
+	}
+	return next.In(loc), true
+}
+
+// JitterWindow returns the longest random delay WithJitter may add to a
+// fire time of the schedule.
+func JitterWindow(schedule models.Schedule) time.Duration {
+	if schedule.Kind != models.ScheduleKindInterval || schedule.Jitter == "" {
+		return 0
+	}
+	jitter, err := time.ParseDuration(schedule.Jitter)
+	if err != nil || jitter < 0 {
+		return 0
+	}
+	return jitter
+}
+
+// WithJitter delays a fire time by a random amount within the schedule's
+// jitter window, spreading jobs that share an interval. Previews and due
+// checks use the undelayed times.
+func WithJitter(schedule models.Schedule, runAt time.Time) time.Time {
+	jitter := JitterWindow(schedule)
+	if jitter <= 0 {
+		return runAt
+	}
+	return runAt.Add(rand.N(jitter))
+}
@@ -0,0 +1,213 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"jobScheduler/models"
+	"slices"
+	"time"
+)
+
+// searchHorizonYears bounds how far ahead Next looks when a schedule has no
+// Years. Any combination of month, day and weekday recurs well within it.
+const searchHorizonYears = 50
+
+// Next returns the first time strictly after t at which the schedule fires.
+// It returns false once the schedule has no occurrences left, which happens
+// when every listed year has passed or the date fields can never match.
+// Calendar times are read as wall-clock times in the schedule's time zone;
+// see wallClock for how daylight saving transitions are handled, and
+// nextInterval for interval schedules.
+func Next(schedule models.Schedule, t time.Time) (time.Time, bool) {
+	if schedule.Kind == models.ScheduleKindInterval {
+		return nextInterval(schedule, t)
+	}
+	if len(schedule.Times) == 0 {
+		return time.Time{}, false
+	}
+
+	loc, err := schedule.Location()
+	if err != nil {
+		return time.Time{}, false
+	}
+	t = t.In(loc)
+
+	times := slices.Clone(schedule.Times)
+	slices.SortFunc(times, func(a, b models.ScheduleTime) int {
+		return (a.Hour*60 + a.Minute) - (b.Hour*60 + b.Minute)
+	})
+
+	lastYear := t.Year() + searchHorizonYears
+	if len(schedule.Years) > 0 {
+		lastYear = slices.Max(schedule.Years)
+	}
+
+	// Walk the calendar one day at a time. Noon is used for the date probe so
+	// that DST transitions around midnight cannot shift it onto another day.
+	for i := 0; ; i++ {
+		day := time.Date(t.Year(), t.Month(), t.Day()+i, 12, 0, 0, 0, loc)
+		if day.Year() > lastYear {
+			return time.Time{}, false
+		}
+		if !matchesDate(schedule, day) {
+			continue
+		}
+		for _, runTime := range times {
+			candidate := wallClock(day.Year(), day.Month(), day.Day(), runTime.Hour, runTime.Minute, 0, loc)
+			if candidate.After(t) {
+				return candidate, true
+			}
+		}
+	}
+}
+
+// matchesDate reports whether the calendar date of t satisfies the year,
+// month, day of month and weekday constraints of the schedule.
+func matchesDate(schedule models.Schedule, t time.Time) bool {
+	if len(schedule.Years) > 0 && !slices.Contains(schedule.Years, t.Year()) {
+		return false
+	}
+	if len(schedule.Months) > 0 && !slices.Contains(schedule.Months, int(t.Month())) {
+		return false
+	}
+	if len(schedule.DaysOfMonth) > 0 && !slices.Contains(schedule.DaysOfMonth, t.Day()) {
+		return false
+	}
+	if len(schedule.Weekdays) > 0 && !slices.Contains(schedule.Weekdays, t.Weekday()) {
+		return false
+	}
+	return true
+}
+
+// NextRun returns the first time strictly after t at which the job fires,
+// using its cron expression when it has one and its Schedule otherwise. Cron
+// expressions are evaluated in the time zone of the job's Schedule.
+func NextRun(job models.Job, t time.Time) (time.Time, bool) {
+	next, ok := runTimes(job)
+	if !ok {
+		return time.Time{}, false
+	}
+	return next(t)
+}
+
+// runTimes returns NextRun for the job with its cron expression and time
+// zone looked up once, for walking through many fire times. It returns
+// false if the job's schedule cannot be used.
+func runTimes(job models.Job) (func(time.Time) (time.Time, bool), bool) {
+	if job.Cron == "" {
+		return func(t time.Time) (time.Time, bool) {
+			return Next(job.Schedule, t)
+		}, true
+	}
+	cron, err := ParseCron(job.Cron)
+	if err != nil {
+		return nil, false
+	}
+	loc, err := job.Schedule.Location()
+	if err != nil {
+		return nil, false
+	}
+	return func(t time.Time) (time.Time, bool) {
+		return cron.Next(t.In(loc))
+	}, true
+}
+
+// ValidateJobSchedule checks that the job has exactly one usable schedule:
+// either a cron expression or a Schedule.
+func ValidateJobSchedule(job models.Job) error {
+	if job.Cron == "" {
+		return job.Schedule.Validate()
+	}
+	if len(job.Schedule.Times) > 0 || job.Schedule.Kind == models.ScheduleKindInterval {
+		return errors.New("provide either a cron expression or a schedule, not both")
+	}
+	if _, err := job.Schedule.Location(); err != nil {
+		return fmt.Errorf("invalid timezone %q: %v", job.Schedule.Timezone, err)
+	}
+	_, err := ParseCron(job.Cron)
+	return err
+}
+
+// NextRuns returns up to n upcoming fire times of the job after from, in
+// order. Fewer are returned when the schedule runs out of occurrences.
+func NextRuns(job models.Job, from time.Time, n int) []time.Time {
+	runs := make([]time.Time, 0, n)
+	next, ok := runTimes(job)
+	if !ok {
+		return runs
+	}
+	t := from
+	for len(runs) < n {
+		run, ok := next(t)
+		if !ok {
+			break
+		}
+		runs = append(runs, run)
+		t = run
+	}
+	return runs
+}
+
+// RunsBetween returns the newest limit fire times of the job in (from, to],
+// oldest first, and how many older ones there were. The older ones are only
+// counted, and interval schedules skip them without walking through them.
+func RunsBetween(job models.Job, from, to time.Time, limit int) ([]time.Time, int) {
+	next, ok := runTimes(job)
+	if !ok || limit <= 0 {
+		return nil, 0
+	}
+
+	skipped := 0
+	if interval, ok := jobInterval(job); ok {
+		// The grid has exactly limit points in (start, to].
+		if start := to.Add(-time.Duration(limit) * interval); start.After(from) {
+			skipped = countInterval(next, from, start, interval, job.Schedule.EndAt)
+			from = start
+		}
+	}
+
+	runs := make([]time.Time, 0, limit)
+	for t := from; ; {
+		run, ok := next(t)
+		if !ok || run.After(to) {
+			return runs, skipped
+		}
+		if len(runs) == limit {
+			runs = append(runs[:0], runs[1:]...)
+			skipped++
+		}
+		runs = append(runs, run)
+		t = run
+	}
+}
+
+// jobInterval returns the interval of a job that fires on an interval
+// schedule.
+func jobInterval(job models.Job) (time.Duration, bool) {
+	if job.Cron != "" || job.Schedule.Kind != models.ScheduleKindInterval {
+		return 0, false
+	}
+	interval, err := time.ParseDuration(job.Schedule.Interval)
+	if err != nil || interval <= 0 {
+		return 0, false
+	}
+	return interval, true
+}
+
+// countInterval counts the fire times in (from, to] of an interval schedule
+// whose next function is next.
+func countInterval(next func(time.Time) (time.Time, bool), from, to time.Time, interval time.Duration, endAt *time.Time) int {
+	first, ok := next(from)
+	if !ok || first.After(to) {
+		return 0
+	}
+	if endAt != nil && endAt.Before(to) {
+		to = *endAt
+	}
+	// The last grid point at or before to; first is on the grid too.
+	last, ok := next(to.Add(-interval))
+	if !ok || last.After(to) {
+		return 1
+	}
+	return int(last.Sub(first)/interval) + 1
+}
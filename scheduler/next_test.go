@@ -0,0 +1,179 @@
+package scheduler
+
+import (
+	"jobScheduler/models"
+	"testing"
+	"time"
+)
+
+// New York springs forward from 02:00 to 03:00 on 2024-03-10 and falls back
+// from 02:00 to 01:00 on 2024-11-03.
+func newYork(t *testing.T) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone data not available: %v", err)
+	}
+	return loc
+}
+
+func TestNextRunDaylightSaving(t *testing.T) {
+	loc := newYork(t)
+	schedule := models.Schedule{Timezone: "America/New_York"}
+
+	tests := []struct {
+		name string
+		job  models.Job
+		from time.Time
+		want time.Time
+	}{
+		{
+			name: "cron time in the spring-forward gap runs at the jump",
+			job:  models.Job{Cron: "30 2 * * *", Schedule: schedule},
+			from: time.Date(2024, 3, 10, 0, 0, 0, 0, loc),
+			want: time.Date(2024, 3, 10, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "cron times in the gap collapse into one run",
+			job:  models.Job{Cron: "*/30 2 * * *", Schedule: schedule},
+			from: time.Date(2024, 3, 10, 7, 0, 0, 0, time.UTC),
+			want: time.Date(2024, 3, 11, 6, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "cron runs at the usual time the day after the gap",
+			job:  models.Job{Cron: "30 2 * * *", Schedule: schedule},
+			from: time.Date(2024, 3, 10, 7, 0, 0, 0, time.UTC),
+			want: time.Date(2024, 3, 11, 6, 30, 0, 0, time.UTC),
+		},
+		{
+			name: "cron time repeated by the fall-back runs at its first occurrence",
+			job:  models.Job{Cron: "30 1 * * *", Schedule: schedule},
+			from: time.Date(2024, 11, 3, 0, 0, 0, 0, loc),
+			want: time.Date(2024, 11, 3, 5, 30, 0, 0, time.UTC),
+		},
+		{
+			name: "cron time repeated by the fall-back does not run twice",
+			job:  models.Job{Cron: "30 1 * * *", Schedule: schedule},
+			from: time.Date(2024, 11, 3, 5, 30, 0, 0, time.UTC),
+			want: time.Date(2024, 11, 4, 6, 30, 0, 0, time.UTC),
+		},
+		{
+			name: "hourly cron skips the repeated hour",
+			job:  models.Job{Cron: "0 * * * *", Schedule: schedule},
+			from: time.Date(2024, 11, 3, 5, 0, 0, 0, time.UTC),
+			want: time.Date(2024, 11, 3, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "schedule time in the spring-forward gap runs at the jump",
+			job:  models.Job{Schedule: models.Schedule{Timezone: "America/New_York", Times: []models.ScheduleTime{{Hour: 2, Minute: 30}}}},
+			from: time.Date(2024, 3, 10, 0, 0, 0, 0, loc),
+			want: time.Date(2024, 3, 10, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "schedule time repeated by the fall-back runs once",
+			job:  models.Job{Schedule: models.Schedule{Timezone: "America/New_York", Times: []models.ScheduleTime{{Hour: 1, Minute: 30}}}},
+			from: time.Date(2024, 11, 3, 5, 30, 0, 0, time.UTC),
+			want: time.Date(2024, 11, 4, 6, 30, 0, 0, time.UTC),
+		},
+		{
+			name: "schedule dates are read in its time zone",
+			job:  models.Job{Schedule: models.Schedule{Timezone: "America/New_York", Weekdays: []time.Weekday{time.Monday}, Times: []models.ScheduleTime{{Hour: 22, Minute: 0}}}},
+			from: time.Date(2024, 6, 4, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2024, 6, 4, 2, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := NextRun(tt.job, tt.from)
+			if !ok || !got.Equal(tt.want) {
+				t.Errorf("NextRun(%s) = %s, %v; want %s", tt.from, got.UTC(), ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextRuns(t *testing.T) {
+	job := models.Job{Schedule: models.Schedule{
+		Timezone:    "UTC",
+		Years:       []int{2024},
+		Months:      []int{12},
+		DaysOfMonth: []int{30, 31},
+		Times:       []models.ScheduleTime{{Hour: 18, Minute: 0}, {Hour: 6, Minute: 0}},
+	}}
+	from := time.Date(2024, 12, 30, 12, 0, 0, 0, time.UTC)
+
+	got := NextRuns(job, from, 5)
+	want := []time.Time{
+		time.Date(2024, 12, 30, 18, 0, 0, 0, time.UTC),
+		time.Date(2024, 12, 31, 6, 0, 0, 0, time.UTC),
+		time.Date(2024, 12, 31, 18, 0, 0, 0, time.UTC),
+	}
+	if len(got) != len(want) {
+		t.Fatalf("NextRuns = %v, want %v", got, want)
+	}
+	for i := range want {
+		if !got[i].Equal(want[i]) {
+			t.Errorf("NextRuns[%d] = %s, want %s", i, got[i], want[i])
+		}
+	}
+}
+
+func TestRunsBetween(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	endAt := time.Date(2024, 1, 1, 0, 0, 10, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		job     models.Job
+		to      time.Time
+		limit   int
+		want    []time.Time
+		skipped int
+	}{
+		{
+			name:  "keeps all when there are fewer than limit",
+			job:   models.Job{Cron: "0 * * * *"},
+			to:    time.Date(2024, 1, 1, 2, 30, 0, 0, time.UTC),
+			limit: 5,
+			want:  []time.Time{time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC), time.Date(2024, 1, 1, 2, 0, 0, 0, time.UTC)},
+		},
+		{
+			name:    "keeps the newest and counts the rest",
+			job:     models.Job{Cron: "* * * * *"},
+			to:      time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+			limit:   2,
+			want:    []time.Time{time.Date(2024, 1, 1, 23, 59, 0, 0, time.UTC), time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+			skipped: 24*60 - 2,
+		},
+		{
+			name:    "skips over an interval schedule",
+			job:     models.Job{Schedule: models.Schedule{Kind: models.ScheduleKindInterval, Interval: "1s", Timezone: "UTC"}},
+			to:      time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+			limit:   2,
+			want:    []time.Time{time.Date(2024, 12, 31, 23, 59, 59, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+			skipped: 366*24*60*60 - 2,
+		},
+		{
+			name:    "counts an interval schedule up to its end",
+			job:     models.Job{Schedule: models.Schedule{Kind: models.ScheduleKindInterval, Interval: "1s", Timezone: "UTC", EndAt: &endAt}},
+			to:      time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC),
+			limit:   2,
+			skipped: 10,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, skipped := RunsBetween(tt.job, from, tt.to, tt.limit)
+			if len(got) != len(tt.want) || skipped != tt.skipped {
+				t.Fatalf("RunsBetween = %v, %d; want %v, %d", got, skipped, tt.want, tt.skipped)
+			}
+			for i := range tt.want {
+				if !got[i].Equal(tt.want[i]) {
+					t.Errorf("RunsBetween[%d] = %s, want %s", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
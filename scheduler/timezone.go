@@ -0,0 +1,45 @@
+package scheduler
+
+import "time"
+
+// wallClock returns the instant at which a clock in loc shows the given date
+// and time, resolving daylight saving transitions as follows:
+//
+//   - A time skipped when clocks spring forward (02:30 on a night that jumps
+//     from 02:00 to 03:00) maps to the moment of the jump, so it still runs
+//     once that day. Several skipped times collapse into that single run.
+//   - A time repeated when clocks fall back (01:30 on a night that goes from
+//     02:00 back to 01:00) maps to its first occurrence only.
+func wallClock(year int, month time.Month, day, hour, minute, second int, loc *time.Location) time.Time {
+	t := time.Date(year, month, day, hour, minute, second, 0, loc)
+
+	if t.Day() != day || t.Hour() != hour || t.Minute() != minute {
+		// The wall time does not exist. time.Date has moved it out of the
+		// gap, to either side depending on the Go version.
+		start, end := t.ZoneBounds()
+		requested := time.Date(year, month, day, hour, minute, second, 0, time.UTC)
+		shown := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+		if shown.Before(requested) {
+			return end
+		}
+		return start
+	}
+
+	start, _ := t.ZoneBounds()
+	if start.IsZero() {
+		return t
+	}
+	_, offset := t.Zone()
+	_, previousOffset := start.Add(-time.Nanosecond).Zone()
+	if previousOffset <= offset {
+		return t
+	}
+
+	// Clocks went back when the current zone started, so the same wall time
+	// may also have occurred under the previous offset.
+	earlier := t.Add(-time.Duration(previousOffset-offset) * time.Second)
+	if earlier.Before(start) {
+		return earlier
+	}
+	return t
+}
@@ -0,0 +1,68 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// ErrNotConfigured is returned when no master key has been configured.
+var ErrNotConfigured = errors.New("secrets are disabled: SECRETS_MASTER_KEY is not set")
+
+var aead cipher.AEAD
+
+// Configure sets the master key secrets are encrypted with. A nil key
+// leaves secrets disabled.
+func Configure(masterKey []byte) error {
+	if masterKey == nil {
+		aead = nil
+		return nil
+	}
+	block, err := aes.NewCipher(masterKey)
+	if err != nil {
+		return fmt.Errorf("invalid master key: %v", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	aead = gcm
+	return nil
+}
+
+// Enabled reports whether a master key has been configured.
+func Enabled() bool {
+	return aead != nil
+}
+
+// Encrypt seals plaintext with AES-GCM under a fresh random nonce, which is
+// prepended to the result. The additional data binds the ciphertext to its
+// secret, so it cannot be moved to another one.
+func Encrypt(plaintext []byte, additionalData string) ([]byte, error) {
+	if aead == nil {
+		return nil, ErrNotConfigured
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, []byte(additionalData)), nil
+}
+
+// Decrypt opens data sealed by Encrypt with the same additional data.
+func Decrypt(data []byte, additionalData string) ([]byte, error) {
+	if aead == nil {
+		return nil, ErrNotConfigured
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(additionalData))
+	if err != nil {
+		return nil, errors.New("secret cannot be decrypted with the configured master key")
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,193 @@
+package secrets
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"jobScheduler/models"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Seal encrypts value into the secret's Ciphertext.
+func Seal(secret *models.Secret, value string) error {
+	ciphertext, err := Encrypt([]byte(value), secret.AdditionalData())
+	if err != nil {
+		return err
+	}
+	secret.Ciphertext = ciphertext
+	return nil
+}
+
+// Open decrypts the secret's value.
+func Open(secret models.Secret) (string, error) {
+	value, err := Decrypt(secret.Ciphertext, secret.AdditionalData())
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+// VisibleTo returns a query for the secrets the user may use: their own and
+// those of their team.
+func VisibleTo(db *gorm.DB, user models.User) *gorm.DB {
+	if user.Team == "" {
+		return db.Where("user_id = ? AND team = ''", user.ID)
+	}
+	return db.Where("(user_id = ? AND team = '') OR (user_id = 0 AND team = ?)", user.ID, user.Team)
+}
+
+// Resolve decrypts the named secrets for a job owned by userID. A user's own
+// secret takes precedence over a team secret of the same name.
+func Resolve(db *gorm.DB, userID uint, names []string) (map[string]string, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	if !Enabled() {
+		return nil, ErrNotConfigured
+	}
+
+	var owner models.User
+	if err := db.First(&owner, userID).Error; err != nil {
+		return nil, fmt.Errorf("cannot load secrets of user %d: %v", userID, err)
+	}
+	var found []models.Secret
+	if err := VisibleTo(db, owner).Where("name IN ?", names).Find(&found).Error; err != nil {
+		return nil, err
+	}
+	byName := make(map[string]models.Secret, len(found))
+	for _, secret := range found {
+		if existing, ok := byName[secret.Name]; ok && existing.Team == "" {
+			continue
+		}
+		byName[secret.Name] = secret
+	}
+
+	values := make(map[string]string, len(names))
+	for _, name := range names {
+		secret, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("secret %q not found", name)
+		}
+		value, err := Open(secret)
+		if err != nil {
+			return nil, fmt.Errorf("secret %q: %v", name, err)
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+// CheckJobSecrets verifies that a job owned by userID can use the named
+// secrets.
+func CheckJobSecrets(db *gorm.DB, userID uint, names []string) error {
+	for _, name := range names {
+		if !models.IsValidEnvName(name) {
+			return fmt.Errorf("invalid secret name %q", name)
+		}
+	}
+	_, err := Resolve(db, userID, names)
+	return err
+}
+
+// redactedValue replaces secret values in output and logs.
+const redactedValue = "[REDACTED]"
+
+// Redactor removes secret values from text.
+type Redactor struct {
+	replacer *strings.Replacer
+	values   []string // longest first
+}
+
+// NewRedactor returns a Redactor for the given secret values.
+func NewRedactor(values map[string]string) *Redactor {
+	var sorted []string
+	for _, value := range values {
+		if value != "" {
+			sorted = append(sorted, value)
+		}
+	}
+	// Replace longer values first, in case one secret contains another.
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	var pairs []string
+	for _, value := range sorted {
+		pairs = append(pairs, value, redactedValue)
+	}
+	return &Redactor{replacer: strings.NewReplacer(pairs...), values: sorted}
+}
+
+// Redact returns text with every secret value replaced. A nil Redactor
+// returns text unchanged.
+func (r *Redactor) Redact(text string) string {
+	if r == nil {
+		return text
+	}
+	return r.replacer.Replace(text)
+}
+
+// Writer returns a writer that redacts secret values from everything written
+// through it before passing it on to w. Because a value may be split across
+// writes, the end of the text is held back until more arrives or Close is
+// called. Close does not close w.
+func (r *Redactor) Writer(w io.Writer) io.WriteCloser {
+	if r == nil || len(r.values) == 0 {
+		return nopCloser{w}
+	}
+	return &redactingWriter{redactor: r, w: w}
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+type redactingWriter struct {
+	redactor *Redactor
+	w        io.Writer
+	pending  []byte
+}
+
+func (rw *redactingWriter) Write(p []byte) (int, error) {
+	rw.pending = append(rw.pending, p...)
+
+	// A value starting before cut is complete in pending, so everything up
+	// to cut can be redacted now. Values that start before cut and end
+	// after it are written out whole.
+	cut := len(rw.pending) - len(rw.redactor.values[0]) + 1
+	var out bytes.Buffer
+	i := 0
+scan:
+	for i < cut {
+		for _, value := range rw.redactor.values {
+			if bytes.HasPrefix(rw.pending[i:], []byte(value)) {
+				out.WriteString(redactedValue)
+				i += len(value)
+				continue scan
+			}
+		}
+		out.WriteByte(rw.pending[i])
+		i++
+	}
+	if i == 0 {
+		return len(p), nil
+	}
+
+	if _, err := rw.w.Write(out.Bytes()); err != nil {
+		return 0, err
+	}
+	rw.pending = append(rw.pending[:0], rw.pending[i:]...)
+	return len(p), nil
+}
+
+// Close writes out the text still held back.
+func (rw *redactingWriter) Close() error {
+	if len(rw.pending) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(rw.w, rw.redactor.Redact(string(rw.pending)))
+	rw.pending = nil
+	return err
+}
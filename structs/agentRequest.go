@@ -0,0 +1,14 @@
+package structs
+
+import "jobScheduler/worker"
+
+// AgentStartRequest is sent by an agent to start a run it has leased.
+type AgentStartRequest struct {
+	WorkerID int `json:"workerId"` // the agent's worker slot, counting from 1
+}
+
+// AgentOutputRequest carries output of a run from an agent, in the order
+// it was written.
+type AgentOutputRequest struct {
+	Chunks []worker.OutputChunk `json:"chunks"`
+}
@@ -0,0 +1,5 @@
+package structs
+
+type RunJobRequest struct {
+	Params map[string]string `json:"params"`
+}
@@ -0,0 +1,8 @@
+package structs
+
+import "jobScheduler/models"
+
+type SchedulePreviewRequest struct {
+	Schedule models.Schedule `json:"schedule"`
+	Cron     string          `json:"cron"`
+}
@@ -0,0 +1,7 @@
+package structs
+
+type SecretRequest struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	Team  string `json:"team"` // share with a team instead of keeping it personal
+}
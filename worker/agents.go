@@ -0,0 +1,491 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"jobScheduler/logger"
+	"jobScheduler/models"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AgentHeartbeatInterval is how often agents send a heartbeat. Each one
+// renews the leases of the agent's runs, so an agent that stops sending them
+// loses its runs once they expire after leaseDuration.
+const AgentHeartbeatInterval = 5 * time.Second
+
+// MaxLeaseWait limits how long a lease request of an agent waits for a run.
+const MaxLeaseWait = time.Minute
+
+// agentRetention is how long agents that stopped sending heartbeats are
+// still listed.
+const agentRetention = 24 * time.Hour
+
+var (
+	// ErrUnknownAgent is returned for agents that are not registered, or no
+	// longer are; the agent should register again.
+	ErrUnknownAgent = errors.New("agent is not registered")
+	// ErrNotLeased is returned when an agent reports on a run it does not
+	// hold, for example because its lease expired.
+	ErrNotLeased = errors.New("run is not leased by this agent")
+	// ErrRunDropped is returned by StartAgentRun for runs that are not to be
+	// executed after all. The agent should move on to the next one.
+	ErrRunDropped = errors.New("run is not to be executed")
+)
+
+// AgentAssignment is what an agent receives when it starts a run.
+type AgentAssignment struct {
+	ExecutionID uint       `json:"executionId"`
+	Job         models.Job `json:"job"` // with its command rendered
+	// Secrets are the values of the job's secrets. The server redacts them
+	// from the output the agent sends back.
+	Secrets map[string]string `json:"secrets,omitempty"`
+	// TimeoutSeconds limits the run; zero means no limit.
+	TimeoutSeconds int64 `json:"timeoutSeconds"`
+}
+
+// OutputChunk is output of a run sent by an agent.
+type OutputChunk struct {
+	Stream string `json:"stream"` // StreamStdout or StreamStderr
+	Data   []byte `json:"data"`
+}
+
+// AgentResult is how a run executed by an agent ended.
+type AgentResult struct {
+	// Status is succeeded, failed, timed_out or cancelled.
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	ExitCode   *int   `json:"exitCode,omitempty"`
+	Signal     string `json:"signal,omitempty"`
+	StatusCode int    `json:"statusCode,omitempty"`
+	LatencyMs  int64  `json:"latencyMs,omitempty"`
+	Response   string `json:"response,omitempty"`
+}
+
+// AgentStatus is an agent as listed to users.
+type AgentStatus struct {
+	models.Agent
+	Online  bool `json:"online"`
+	Running int  `json:"running"`
+}
+
+// agentRun is a run executing on an agent, from the point of view of the
+// instance that started it. The output and result the agent sends are
+// forwarded to that instance, which keeps the run's log.
+type agentRun struct {
+	*activeRun
+	db      *gorm.DB
+	agentID uint
+	item    models.QueueItem
+
+	mu        sync.Mutex
+	cancelled bool
+	done      bool // set once finish has been called; no output is taken after it
+}
+
+// cancel records that the run is to be stopped. The agent learns about it
+// from the answer to its next output or heartbeat, whichever instance that
+// reaches.
+func (r *agentRun) cancel(error) {
+	r.mu.Lock()
+	r.cancelled = true
+	r.mu.Unlock()
+	if err := r.db.Model(&models.QueueItem{}).Where("id = ?", r.item.ID).Update("cancel_requested", true).Error; err != nil {
+		logger.L.Error("Failed to record the cancellation of an agent run", "execution_id", r.item.ExecutionID, "error", err)
+	}
+}
+
+func (r *agentRun) cancelRequested() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cancelled
+}
+
+var (
+	agentRunsMu sync.Mutex
+	agentRuns   = make(map[uint]*agentRun) // by execution ID
+)
+
+// agentHolder is what the leases of an agent are held by.
+func agentHolder(agent models.Agent) string {
+	return fmt.Sprintf("agent:%d:%s", agent.ID, agent.Name)
+}
+
+// RegisterAgent checks and stores a new agent. An agent without queues
+// takes runs from the default queue.
+func RegisterAgent(db *gorm.DB, agent *models.Agent) error {
+	if agent.Name == "" {
+		return errors.New("agent name is required")
+	}
+	if len(agent.Queues) == 0 {
+		agent.Queues = []string{models.DefaultQueue}
+	}
+	for _, name := range agent.Queues {
+		if name == "" {
+			return errors.New("queue names must not be empty")
+		}
+		if err := ValidateQueue(name); err != nil {
+			return err
+		}
+	}
+	if agent.Workers <= 0 {
+		agent.Workers = 1
+	}
+	if err := ValidateNodeSelector(agent.Labels); err != nil {
+		return errors.New("agent label keys must not be empty")
+	}
+	agent.Labels = withHostname(agent.Labels, agent.Hostname)
+	agent.ID = 0
+	agent.LastHeartbeatAt = time.Now()
+	if err := db.Create(agent).Error; err != nil {
+		return err
+	}
+	logger.L.Info("Agent registered", "agent_id", agent.ID, "name", agent.Name, "hostname", agent.Hostname, "queues", agent.Queues, "labels", agent.Labels, "workers", agent.Workers)
+	return nil
+}
+
+// LoadAgent returns a registered agent.
+func LoadAgent(db *gorm.DB, agentID uint) (models.Agent, error) {
+	var agent models.Agent
+	err := db.First(&agent, agentID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return agent, ErrUnknownAgent
+	}
+	return agent, err
+}
+
+// AgentHeartbeat records that the agent is alive and renews the leases of
+// its runs. It returns the executions of the agent that were cancelled.
+//
+// Only the leases of runs the agent has started are renewed: a run leased by
+// a lease request whose response never reached the agent is re-queued once
+// its first lease expires. The runs are looked up in the database, so the
+// heartbeat may reach any instance.
+func AgentHeartbeat(db *gorm.DB, agent models.Agent) ([]uint, error) {
+	now := time.Now()
+	if err := db.Model(&agent).Update("last_heartbeat_at", now).Error; err != nil {
+		return nil, err
+	}
+
+	var items []models.QueueItem
+	running := db.Model(&models.JobExecution{}).Select("id").Where("status = ?", models.ExecutionStatusRunning)
+	err := db.Where("state = ? AND leased_by = ? AND execution_id IN (?)", models.QueueStateLeased, agentHolder(agent), running).
+		Find(&items).Error
+	if err != nil {
+		return nil, err
+	}
+
+	started := []uint{}
+	cancelled := []uint{}
+	for _, item := range items {
+		started = append(started, item.ID)
+		if item.CancelRequested {
+			cancelled = append(cancelled, item.ExecutionID)
+		}
+	}
+	if len(started) > 0 {
+		err := db.Model(&models.QueueItem{}).
+			Where("state = ? AND leased_by = ? AND id IN ?", models.QueueStateLeased, agentHolder(agent), started).
+			Update("lease_expires_at", now.Add(leaseDuration)).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	return cancelled, nil
+}
+
+// LeaseForAgent waits up to wait for a run from the agent's queues and
+// leases it to the agent. It returns false if none became available.
+func LeaseForAgent(db *gorm.DB, agent models.Agent, wait time.Duration) (models.QueueItem, bool) {
+	deadline := time.Now().Add(wait)
+	for {
+		changed := queueWait()
+		if item, ok := lease(db, agent.Queues, agentHolder(agent), agent.Labels); ok {
+			logger.L.Info("Agent leased a run", "agent_id", agent.ID, "item_id", item.ID, "execution_id", item.ExecutionID)
+			return item, true
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return models.QueueItem{}, false
+		}
+		timer := time.NewTimer(min(remaining, queuePollInterval))
+		select {
+		case <-changed:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// StartAgentRun starts a run that the agent has leased, as slot workerID of
+// the agent, and returns what the agent needs to execute it. It returns
+// ErrRunDropped if the run is not to be executed after all, and records the
+// run as failed if it cannot be set up.
+func StartAgentRun(db *gorm.DB, agent models.Agent, executionID uint, workerID int) (AgentAssignment, error) {
+	var item models.QueueItem
+	result := db.Where("execution_id = ? AND state = ? AND leased_by = ?", executionID, models.QueueStateLeased, agentHolder(agent)).
+		Limit(1).
+		Find(&item)
+	if result.Error != nil {
+		return AgentAssignment{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return AgentAssignment{}, ErrNotLeased
+	}
+
+	run, ok := runFor(db, item)
+	if !ok {
+		completeItem(db, item)
+		return AgentAssignment{}, ErrRunDropped
+	}
+	run.WorkerID = workerID
+	run.Agent = &agent
+
+	ar := &agentRun{db: db, agentID: agent.ID, item: item}
+	r, _, ok := beginRun(db, run, ar.cancel)
+	if !ok {
+		completeItem(db, item)
+		return AgentAssignment{}, ErrRunDropped
+	}
+	ar.activeRun = r
+
+	if r.setupErr != nil {
+		fmt.Fprintln(r.stderr, r.setupErr)
+		r.finish(db, attemptOutcome{status: models.ExecutionStatusFailed, err: r.setupErr, exitCode: -1})
+		completeItem(db, item)
+		return AgentAssignment{}, ErrRunDropped
+	}
+
+	agentRunsMu.Lock()
+	agentRuns[r.execution.ID] = ar
+	agentRunsMu.Unlock()
+	logger.L.Info("Agent started a run", "agent_id", agent.ID, "job_id", r.job.ID, "execution_id", r.execution.ID, "worker_id", workerID)
+
+	return AgentAssignment{
+		ExecutionID:    r.execution.ID,
+		Job:            r.job,
+		Secrets:        r.secrets,
+		TimeoutSeconds: int64(JobTimeout(r.job) / time.Second),
+	}, nil
+}
+
+func lookupAgentRun(agent models.Agent, executionID uint) (*agentRun, error) {
+	agentRunsMu.Lock()
+	r, ok := agentRuns[executionID]
+	agentRunsMu.Unlock()
+	if !ok || r.agentID != agent.ID {
+		return nil, ErrNotLeased
+	}
+	return r, nil
+}
+
+// AgentOutput adds output an agent sent for a run. It reports whether the
+// run has been cancelled in the meantime.
+func AgentOutput(agent models.Agent, executionID uint, chunks []OutputChunk) (bool, error) {
+	r, err := lookupAgentRun(agent, executionID)
+	if err != nil {
+		return false, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.done {
+		return false, ErrNotLeased
+	}
+	for _, chunk := range chunks {
+		switch chunk.Stream {
+		case StreamStdout:
+			r.stdout.Write(chunk.Data)
+		case StreamStderr:
+			r.stderr.Write(chunk.Data)
+		default:
+			return false, fmt.Errorf("invalid stream %q: must be %s or %s", chunk.Stream, StreamStdout, StreamStderr)
+		}
+	}
+	return r.cancelled, nil
+}
+
+// take removes the run from agentRuns so that it can be finished. It
+// returns false if it was taken already.
+func (r *agentRun) take(executionID uint) bool {
+	agentRunsMu.Lock()
+	if agentRuns[executionID] != r {
+		agentRunsMu.Unlock()
+		return false
+	}
+	delete(agentRuns, executionID)
+	agentRunsMu.Unlock()
+
+	r.mu.Lock()
+	r.done = true
+	r.mu.Unlock()
+	return true
+}
+
+// FinishAgentRun records how a run executed by the agent ended.
+func FinishAgentRun(db *gorm.DB, agent models.Agent, executionID uint, result AgentResult) (models.JobExecution, error) {
+	r, err := lookupAgentRun(agent, executionID)
+	if err != nil {
+		return models.JobExecution{}, err
+	}
+	switch result.Status {
+	case models.ExecutionStatusSucceeded, models.ExecutionStatusFailed, models.ExecutionStatusTimedOut, models.ExecutionStatusCancelled:
+	default:
+		return models.JobExecution{}, fmt.Errorf("invalid status %q: must be succeeded, failed, timed_out or cancelled", result.Status)
+	}
+
+	if !r.take(executionID) {
+		// Finished by another request in the meantime.
+		return models.JobExecution{}, ErrNotLeased
+	}
+
+	r.execution.ExitCode = result.ExitCode
+	r.execution.Signal = result.Signal
+	r.execution.StatusCode = result.StatusCode
+	r.execution.LatencyMs = result.LatencyMs
+	r.execution.Response = result.Response
+
+	outcome := attemptOutcome{status: result.Status, exitCode: -1}
+	if result.ExitCode != nil {
+		outcome.exitCode = *result.ExitCode
+	}
+	switch {
+	case result.Status == models.ExecutionStatusSucceeded:
+	case result.Status == models.ExecutionStatusCancelled:
+		outcome.err = errCancelled
+	case result.Error != "":
+		outcome.err = errors.New(result.Error)
+	default:
+		outcome.err = errors.New(result.Status)
+	}
+
+	execution := r.finish(db, outcome)
+	completeItem(db, r.item)
+	return execution, nil
+}
+
+// abandonAgentRun ends a run started by this process on an agent that went
+// away. It returns false if this process knows no such run.
+func abandonAgentRun(db *gorm.DB, executionID uint, reason string) bool {
+	agentRunsMu.Lock()
+	r, ok := agentRuns[executionID]
+	agentRunsMu.Unlock()
+	if !ok || !r.take(executionID) {
+		return false
+	}
+	fmt.Fprintln(r.stderr, reason)
+	r.finish(db, attemptOutcome{status: models.ExecutionStatusAbandoned, err: errors.New(reason), exitCode: -1})
+	return true
+}
+
+// pruneAgentRuns ends the agent runs of this process whose lease another
+// instance has reclaimed, since their agent went away.
+func pruneAgentRuns(db *gorm.DB) {
+	agentRunsMu.Lock()
+	items := make(map[uint]models.QueueItem, len(agentRuns))
+	for executionID, r := range agentRuns {
+		items[executionID] = r.item
+	}
+	agentRunsMu.Unlock()
+
+	for executionID, item := range items {
+		var count int64
+		err := db.Model(&models.QueueItem{}).
+			Where("id = ? AND state = ? AND leased_by = ?", item.ID, models.QueueStateLeased, item.LeasedBy).
+			Count(&count).Error
+		if err != nil || count > 0 {
+			continue
+		}
+		abandonAgentRun(db, executionID, fmt.Sprintf("worker %s went away", item.LeasedBy))
+	}
+}
+
+// ListAgents returns the registered agents, the most recently seen first.
+func ListAgents(db *gorm.DB) ([]AgentStatus, error) {
+	var agents []models.Agent
+	if err := db.Order("last_heartbeat_at DESC").Find(&agents).Error; err != nil {
+		return nil, err
+	}
+
+	var counts []struct {
+		LeasedBy string
+		Count    int
+	}
+	running := db.Model(&models.JobExecution{}).Select("id").Where("status = ?", models.ExecutionStatusRunning)
+	err := db.Model(&models.QueueItem{}).
+		Select("leased_by, count(*) AS count").
+		Where("state = ? AND leased_by LIKE ? AND execution_id IN (?)", models.QueueStateLeased, "agent:%", running).
+		Group("leased_by").
+		Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+	byHolder := make(map[string]int, len(counts))
+	for _, c := range counts {
+		byHolder[c.LeasedBy] = c.Count
+	}
+
+	statuses := make([]AgentStatus, 0, len(agents))
+	for _, agent := range agents {
+		statuses = append(statuses, AgentStatus{
+			Agent:   agent,
+			Online:  time.Since(agent.LastHeartbeatAt) < leaseDuration,
+			Running: byHolder[agentHolder(agent)],
+		})
+	}
+	return statuses, nil
+}
+
+// removeOldAgents drops agents that have not been seen for agentRetention.
+func removeOldAgents(db *gorm.DB, now time.Time) {
+	if err := db.Where("last_heartbeat_at < ?", now.Add(-agentRetention)).Delete(&models.Agent{}).Error; err != nil {
+		logger.L.Error("Failed to remove old agents", "error", err)
+	}
+}
+
+// ExecuteAssignment executes a run on an agent the way a pool worker would.
+// Cancelling ctx stops the run as cancelled. Output goes to stdout and
+// stderr as it is produced.
+func ExecuteAssignment(ctx context.Context, assignment AgentAssignment, stdout, stderr io.Writer, started func(pid int)) AgentResult {
+	base := ctx
+	timeout := time.Duration(assignment.TimeoutSeconds) * time.Second
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	execution := models.JobExecution{JobID: assignment.Job.ID}
+	execution.ID = assignment.ExecutionID
+	executor, err := executorFor(assignment.Job)
+	if err == nil {
+		var output string
+		output, err = executor.Execute(ctx, &Attempt{Job: assignment.Job, Execution: &execution, Secrets: assignment.Secrets, Stdout: stdout, Stderr: stderr, started: started})
+		io.WriteString(stdout, output)
+	} else {
+		fmt.Fprintln(stderr, err)
+	}
+
+	result := AgentResult{
+		Status:     models.ExecutionStatusSucceeded,
+		ExitCode:   execution.ExitCode,
+		Signal:     execution.Signal,
+		StatusCode: execution.StatusCode,
+		LatencyMs:  execution.LatencyMs,
+		Response:   execution.Response,
+	}
+	switch {
+	case base.Err() != nil:
+		result.Status = models.ExecutionStatusCancelled
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		result.Status = models.ExecutionStatusTimedOut
+		result.Error = fmt.Sprintf("timed out after %s", timeout)
+	case err != nil:
+		result.Status = models.ExecutionStatusFailed
+		result.Error = err.Error()
+	}
+	return result
+}
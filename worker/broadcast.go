@@ -0,0 +1,192 @@
+package worker
+
+import (
+	"bytes"
+	"sync"
+)
+
+// Streams of a LogLine.
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// replayLines is how many of the latest lines of a running execution are
+// kept for subscribers that join late.
+const replayLines = 1000
+
+// maxLineBytes splits lines that are longer.
+const maxLineBytes = 4 << 10
+
+// subscriberBuffer is how many lines a subscriber may fall behind before it
+// is dropped.
+const subscriberBuffer = 256
+
+// LogLine is one line of an execution's output, without its newline.
+type LogLine struct {
+	// Seq is the offset in the execution's log just after the line, so that
+	// a line has the same number live and when read back from the log.
+	Seq    int    `json:"seq"`
+	Stream string `json:"stream,omitempty"`
+	Text   string `json:"text"`
+}
+
+// logBroadcast fans out the output lines of a running execution to its
+// subscribers.
+type logBroadcast struct {
+	mu          sync.Mutex
+	lines       []LogLine         // the last replayLines lines
+	size        int               // of the lines published so far, as logged
+	partial     map[string][]byte // unfinished line of each stream
+	subscribers map[*LogSubscription]struct{}
+}
+
+var (
+	broadcastsMu sync.Mutex
+	broadcasts   = make(map[uint]*logBroadcast)
+)
+
+// startBroadcast makes the output of an execution available to
+// SubscribeLog until finishBroadcast is called.
+func startBroadcast(executionID uint) *logBroadcast {
+	b := &logBroadcast{
+		partial:     make(map[string][]byte),
+		subscribers: make(map[*LogSubscription]struct{}),
+	}
+	broadcastsMu.Lock()
+	broadcasts[executionID] = b
+	broadcastsMu.Unlock()
+	return b
+}
+
+// finishBroadcast ends every subscription of the execution. Its output
+// must have been flushed.
+func finishBroadcast(executionID uint, b *logBroadcast) {
+	broadcastsMu.Lock()
+	delete(broadcasts, executionID)
+	broadcastsMu.Unlock()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subscribers {
+		close(sub.lines)
+	}
+	b.subscribers = nil
+}
+
+// write splits the output of a stream into lines, publishes them, and
+// returns them as they go into the log: whole lines only, and those longer
+// than maxLineBytes in pieces, so that the log holds the lines in the order
+// they were published and each ends at the offset it is numbered by.
+func (b *logBroadcast) write(stream string, p []byte) []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var logged []byte
+	data := append(b.partial[stream], p...)
+	for {
+		end := bytes.IndexByte(data, '\n')
+		if (end < 0 || end > maxLineBytes) && len(data) > maxLineBytes {
+			logged = append(logged, data[:maxLineBytes]...)
+			b.publish(stream, data[:maxLineBytes], maxLineBytes)
+			data = data[maxLineBytes:]
+			continue
+		}
+		if end < 0 {
+			break
+		}
+		logged = append(logged, data[:end+1]...)
+		b.publish(stream, data[:end], end+1)
+		data = data[end+1:]
+	}
+	b.partial[stream] = append(b.partial[stream][:0], data...)
+	return logged
+}
+
+// flush publishes the unfinished lines and returns them as they go into the
+// log, each ended with a newline.
+func (b *logBroadcast) flush() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var logged []byte
+	for _, stream := range []string{StreamStdout, StreamStderr} {
+		if text := b.partial[stream]; len(text) > 0 {
+			logged = append(append(logged, text...), '\n')
+			b.publish(stream, text, len(text)+1)
+			b.partial[stream] = nil
+		}
+	}
+	return logged
+}
+
+// publish sends a line that takes up logged bytes of the log. It must be
+// called with b.mu held.
+func (b *logBroadcast) publish(stream string, text []byte, logged int) {
+	b.size += logged
+	line := LogLine{Seq: b.size, Stream: stream, Text: string(text)}
+	b.lines = append(b.lines, line)
+	if len(b.lines) > 2*replayLines {
+		b.lines = append([]LogLine(nil), b.lines[len(b.lines)-replayLines:]...)
+	}
+	for sub := range b.subscribers {
+		select {
+		case sub.lines <- line:
+		default:
+			// Too slow; it can subscribe again from the last line it got.
+			sub.Lagged = true
+			close(sub.lines)
+			delete(b.subscribers, sub)
+		}
+	}
+}
+
+// LogSubscription follows the output of a running execution.
+type LogSubscription struct {
+	// Lines is closed when the execution has finished, or if the subscriber
+	// fell too far behind, in which case Lagged is set.
+	Lines  <-chan LogLine
+	Lagged bool
+
+	lines     chan LogLine
+	broadcast *logBroadcast
+}
+
+// SubscribeLog returns the buffered lines of a running execution after the
+// line numbered after, and a subscription to the lines that follow. It
+// returns false if the execution is not running.
+func SubscribeLog(executionID uint, after int) ([]LogLine, *LogSubscription, bool) {
+	broadcastsMu.Lock()
+	b, ok := broadcasts[executionID]
+	broadcastsMu.Unlock()
+	if !ok {
+		return nil, nil, false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers == nil {
+		// Finished in the meantime.
+		return nil, nil, false
+	}
+	var replay []LogLine
+	start := max(len(b.lines)-replayLines, 0)
+	for _, line := range b.lines[start:] {
+		if line.Seq > after {
+			replay = append(replay, line)
+		}
+	}
+	lines := make(chan LogLine, subscriberBuffer)
+	sub := &LogSubscription{Lines: lines, lines: lines, broadcast: b}
+	b.subscribers[sub] = struct{}{}
+	return replay, sub, true
+}
+
+// Close stops the subscription.
+func (s *LogSubscription) Close() {
+	b := s.broadcast
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subscribers[s]; ok {
+		delete(b.subscribers, s)
+		close(s.lines)
+	}
+}
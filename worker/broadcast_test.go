@@ -0,0 +1,56 @@
+package worker
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestLogLinesMatchBroadcast checks that the lines read back from a log are
+// split and numbered as they were sent live.
+func TestLogLinesMatchBroadcast(t *testing.T) {
+	logDir = t.TempDir()
+	log, err := createLogFile(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := startBroadcast(1)
+	_, sub, _ := SubscribeLog(1, 0)
+	output := newExecutionOutput(log, b)
+
+	output.Stdout().Write([]byte("first\nsec"))
+	output.Stdout().Write([]byte("ond\n\n"))
+	output.Stdout().Write([]byte("inter"))
+	output.Stderr().Write([]byte("error\n"))
+	output.Stdout().Write([]byte("leaved\n"))
+	output.Stdout().Write([]byte(strings.Repeat("x", maxLineBytes+10) + "\n" + strings.Repeat("y", maxLineBytes) + "\n"))
+	output.Stdout().Write([]byte("unfinished"))
+	if err := output.Close(); err != nil {
+		t.Fatal(err)
+	}
+	finishBroadcast(1, b)
+
+	var live []LogLine
+	for line := range sub.Lines {
+		live = append(live, line)
+	}
+	replayed, err := ReadLogLines(log.path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(live) != 9 || len(replayed) != len(live) {
+		t.Fatalf("got %d lines live and %d replayed, want 9", len(live), len(replayed))
+	}
+	for i := range live {
+		if live[i].Seq != replayed[i].Seq || live[i].Text != replayed[i].Text {
+			t.Errorf("line %d is %d %q live but %d %q replayed", i, live[i].Seq, live[i].Text, replayed[i].Seq, replayed[i].Text)
+		}
+	}
+
+	rest, err := ReadLogLines(log.path, live[2].Seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 6 || rest[0].Text != "error" {
+		t.Errorf("lines after %d are %v, want the last 6", live[2].Seq, rest)
+	}
+}
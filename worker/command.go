@@ -0,0 +1,161 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"jobScheduler/models"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// commandExecutor runs the Command of a job, configured by an optional
+// CommandSpec.
+type commandExecutor struct{}
+
+func (commandExecutor) Validate(job models.Job) error {
+	if job.Command == "" {
+		return errors.New("command is required")
+	}
+	var spec models.CommandSpec
+	if err := DecodeSpec(job, &spec); err != nil {
+		return err
+	}
+	return spec.Validate(job.Command)
+}
+
+func (commandExecutor) Execute(ctx context.Context, attempt *Attempt) (string, error) {
+	var spec models.CommandSpec
+	if err := DecodeSpec(attempt.Job, &spec); err != nil {
+		return "", err
+	}
+
+	// Jobs created before http jobs existed used a bare URL as their
+	// command; they are run as a GET of that URL.
+	command := strings.TrimSpace(attempt.Job.Command)
+	if attempt.Job.Spec.IsEmpty() && (strings.HasPrefix(command, "http://") || strings.HasPrefix(command, "https://")) && !strings.ContainsAny(command, " \t\n") {
+		return runHTTP(ctx, attempt, &models.HTTPSpec{URL: command})
+	}
+	if len(attempt.Secrets) > 0 {
+		env := make(map[string]string, len(spec.Env)+len(attempt.Secrets))
+		for name, value := range spec.Env {
+			env[name] = value
+		}
+		for name, value := range attempt.Secrets {
+			env[name] = value
+		}
+		spec.Env = env
+	}
+	result, err := ExecuteCommand(ctx, attempt.Job.Command, spec, attempt.Stdout, attempt.Stderr, attempt.Started)
+	attempt.Execution.ExitCode = result.ExitCode
+	attempt.Execution.Signal = result.Signal
+	return "", err
+}
+
+// CommandResult describes how a command ended.
+type CommandResult struct {
+	// ExitCode is nil if the command did not start or was stopped by a
+	// signal, which is then named by Signal.
+	ExitCode *int
+	Signal   string
+}
+
+// cleanPath is the PATH of commands started with a clean environment.
+const cleanPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// ExecuteCommand runs the command in its own process group, through the
+// shell chosen by spec or directly. When ctx is done first, the whole group
+// is sent SIGTERM and, if it is still running after killGracePeriod,
+// SIGKILL. If started is not nil it is called with the process ID once the
+// command is running. Its output is written to stdout and stderr, which may
+// be the same writer.
+func ExecuteCommand(ctx context.Context, command string, spec models.CommandSpec, stdout, stderr io.Writer, started func(pid int)) (CommandResult, error) {
+	var result CommandResult
+
+	var cmd *exec.Cmd
+	switch spec.Shell {
+	case models.ShellNone:
+		args, err := models.SplitCommand(command)
+		if err != nil {
+			return result, err
+		}
+		if len(args) == 0 {
+			return result, errors.New("command is required")
+		}
+		cmd = exec.CommandContext(ctx, args[0], args[1:]...)
+	case models.ShellBash:
+		cmd = exec.CommandContext(ctx, "bash", "-c", command)
+	default:
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	startInOwnGroup(cmd)
+	var killTimer *time.Timer
+	cmd.Cancel = func() error {
+		killTimer = time.AfterFunc(killGracePeriod, func() {
+			killGroup(cmd.Process)
+		})
+		return terminateGroup(cmd.Process)
+	}
+	// Stop waiting for output from descendants that escaped the group.
+	cmd.WaitDelay = killGracePeriod + time.Second
+	cmd.Dir = spec.WorkingDir
+
+	if spec.CleanEnv {
+		cmd.Env = []string{"PATH=" + cleanPath}
+	} else {
+		cmd.Env = os.Environ()
+		// 1. Get the current user's home directory dynamically
+		homeDir, err := os.UserHomeDir()
+		if err == nil {
+			// 2. Safely build the path: e.g., /home/username/.local/bin
+			localBin := filepath.Join(homeDir, ".local", "bin")
+
+			// 3. Prepend it to the existing system PATH
+			customPath := fmt.Sprintf("PATH=%s:%s", localBin, os.Getenv("PATH"))
+
+			// 4. Inject the new environment variables into the command
+			cmd.Env = append(cmd.Env, customPath)
+		} else {
+			// Fallback: If for some reason the OS doesn't know the home dir,
+			// just run it with the default environment variables.
+			fmt.Println("Warning: Could not determine home directory")
+		}
+	}
+	// Later entries win, so the job's variables override inherited ones.
+	names := make([]string, 0, len(spec.Env))
+	for name := range spec.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cmd.Env = append(cmd.Env, name+"="+spec.Env[name])
+	}
+
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return result, err
+	}
+	if started != nil {
+		started(cmd.Process.Pid)
+	}
+	err := cmd.Wait()
+	// Cancel has returned by the time Wait does. Once the process is gone
+	// its group ID may be reused, so it must not be killed later.
+	if killTimer != nil {
+		killTimer.Stop()
+	}
+
+	if state := cmd.ProcessState; state != nil {
+		if code := state.ExitCode(); code >= 0 {
+			result.ExitCode = &code
+		}
+		result.Signal = exitSignal(state)
+	}
+	return result, err
+}
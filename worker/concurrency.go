@@ -0,0 +1,174 @@
+package worker
+
+import (
+	"jobScheduler/logger"
+	"jobScheduler/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// replacedBy is recorded as CancelledBy on executions stopped by the replace
+// concurrency policy.
+const replacedBy = "replace policy"
+
+// concurrencyRetryDelay is how long a run that has to wait for the other runs
+// of its job, under the queue or replace policy, goes back to the queue
+// before it is tried again. It does not hold a worker while it waits.
+const concurrencyRetryDelay = time.Second
+
+// admit applies the job's concurrency policy to a new run and returns the
+// decision. Unless the run is skipped or has to wait, it counts as active
+// for the job until release is called, and later runs of the job are judged
+// against it.
+//
+// The runs of the job on other instances and agents count as well: they are
+// its executions recorded as running. Under the queue policy the new run
+// waits while any is active; under the replace policy admit asks the active
+// runs to stop and the new run waits until they have.
+func admit(db *gorm.DB, job models.Job) (decision string, wait bool) {
+	recorded := hasRunningExecution(db, job.ID)
+
+	runningMu.Lock()
+	busy := activeRuns[job.ID] > 0 || recorded
+	switch {
+	case !busy:
+		decision = models.ConcurrencyDecisionStarted
+	case job.ConcurrencyPolicy == models.ConcurrencyPolicyForbid:
+		runningMu.Unlock()
+		return models.ConcurrencyDecisionSkipped, false
+	case job.ConcurrencyPolicy == models.ConcurrencyPolicyQueue:
+		runningMu.Unlock()
+		return models.ConcurrencyDecisionQueued, true
+	case job.ConcurrencyPolicy == models.ConcurrencyPolicyReplace:
+		runningMu.Unlock()
+		requestStop(db, job.ID)
+		return models.ConcurrencyDecisionReplaced, true
+	default:
+		decision = models.ConcurrencyDecisionAllowed
+	}
+	activeRuns[job.ID]++
+	runningMu.Unlock()
+	return decision, false
+}
+
+// hasRunningExecution reports whether an execution of the job is recorded as
+// running, wherever it runs.
+func hasRunningExecution(db *gorm.DB, jobID uint) bool {
+	var count int64
+	err := db.Model(&models.JobExecution{}).
+		Where("job_id = ? AND status = ?", jobID, models.ExecutionStatusRunning).
+		Count(&count).Error
+	if err != nil {
+		logger.L.Error("Failed to look for running executions", "job_id", jobID, "error", err)
+	}
+	return count > 0
+}
+
+// requestStop asks the running executions of the job to stop under the
+// replace policy by flagging their queue items. The instances holding them
+// stop them, see watchReplaceRequests, and agents learn about it the way
+// they learn about cancellations.
+func requestStop(db *gorm.DB, jobID uint) {
+	executions := db.Model(&models.JobExecution{}).Select("id").
+		Where("job_id = ? AND status = ?", jobID, models.ExecutionStatusRunning)
+	err := db.Model(&models.QueueItem{}).
+		Where("state = ? AND cancel_requested = ? AND execution_id IN (?)", models.QueueStateLeased, false, executions).
+		Update("cancel_requested", true).Error
+	if err != nil {
+		logger.L.Error("Failed to ask running executions to stop", "job_id", jobID, "error", err)
+	}
+}
+
+// watchReplaceRequests stops the runs of this process that the replace
+// policy asked to stop, on any instance. It checks every
+// queuePollInterval for as long as the process runs.
+func watchReplaceRequests(db *gorm.DB) {
+	go func() {
+		ticker := time.NewTicker(queuePollInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			stopReplacedRuns(db)
+		}
+	}()
+}
+
+func stopReplacedRuns(db *gorm.DB) {
+	runningMu.Lock()
+	ids := make([]uint, 0, len(running))
+	for executionID, r := range running {
+		if r.CancelledBy() == "" {
+			ids = append(ids, executionID)
+		}
+	}
+	runningMu.Unlock()
+	if len(ids) == 0 {
+		return
+	}
+
+	var replaced []uint
+	err := db.Model(&models.QueueItem{}).
+		Where("execution_id IN ? AND state = ? AND cancel_requested = ?", ids, models.QueueStateLeased, true).
+		Pluck("execution_id", &replaced).Error
+	if err != nil {
+		logger.L.Error("Failed to look for replaced executions", "error", err)
+		return
+	}
+
+	runningMu.Lock()
+	defer runningMu.Unlock()
+	for _, executionID := range replaced {
+		if r, ok := running[executionID]; ok && r.CancelledBy() == "" {
+			logger.L.Info("Replacing running execution", "job_id", r.jobID, "execution_id", executionID)
+			r.stop(replacedBy)
+		}
+	}
+}
+
+// release ends a run admitted by admit.
+func release(jobID uint) {
+	runningMu.Lock()
+	activeRuns[jobID]--
+	if activeRuns[jobID] <= 0 {
+		delete(activeRuns, jobID)
+	}
+	runningMu.Unlock()
+}
+
+// waitForTurn puts a run that has to wait for the other runs of its job back
+// in the queue for concurrencyRetryDelay. The decision it will start with is
+// recorded on its queued execution.
+func waitForTurn(db *gorm.DB, run Run, queued models.JobExecution, decision string) models.JobExecution {
+	if queued.Concurrency != decision {
+		logger.L.Info("Run waits for the other runs of its job", "job_id", run.Job.ID, "execution_id", run.ExecutionID, "policy", run.Job.ConcurrencyPolicy)
+		db.Model(&queued).Where("status = ?", models.ExecutionStatusQueued).Update("concurrency", decision)
+	}
+	// The lease is given up, so it does not count as a delivery.
+	err := db.Model(&models.QueueItem{}).
+		Where("execution_id = ? AND state = ?", run.ExecutionID, models.QueueStateLeased).
+		Updates(map[string]interface{}{
+			"state":            models.QueueStateEnqueued,
+			"leased_by":        "",
+			"lease_expires_at": nil,
+			"available_at":     time.Now().Add(concurrencyRetryDelay),
+			"deliveries":       gorm.Expr("deliveries - 1"),
+		}).Error
+	if err != nil {
+		logger.L.Error("Failed to queue a waiting run again", "job_id", run.Job.ID, "execution_id", run.ExecutionID, "error", err)
+	}
+	return queued
+}
+
+// skipRun records a run dropped by the forbid policy. The job's LastStatus
+// keeps describing the run that is still executing.
+func skipRun(db *gorm.DB, run Run) models.JobExecution {
+	execution := newExecution(run, models.ExecutionStatusSkipped, models.ConcurrencyDecisionSkipped)
+	execution.FinishedAt = execution.StartedAt
+	if !saveExecution(db, run, &execution) {
+		queued, _ := stillQueued(db, run)
+		return dropRun(run, queued)
+	}
+	notifyFinished(execution.ID)
+	logger.L.Warn("Job is already running. Skipping run.", "job_id", run.Job.ID, "execution_id", execution.ID)
+	return execution
+}
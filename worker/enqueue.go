@@ -0,0 +1,213 @@
+package worker
+
+import (
+	"errors"
+	"jobScheduler/logger"
+	"jobScheduler/models"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrQueueFull is returned by Enqueue when the job queue has no room.
+var ErrQueueFull = errors.New("job queue is full")
+
+// finished holds a channel for every execution queued by this process that
+// is closed once the execution is over.
+var (
+	finishedMu sync.Mutex
+	finished   = make(map[uint]chan struct{})
+)
+
+// Enqueue queues a run and returns its queued execution, so that callers
+// learn its execution ID right away. If the queue is full the run is recorded
+// as a failed execution and ErrQueueFull is returned.
+func Enqueue(db *gorm.DB, run Run) (models.JobExecution, error) {
+	execution, err := enqueue(db, run, time.Now())
+	if !errors.Is(err, ErrQueueFull) {
+		if err == nil && execution.Status == models.ExecutionStatusQueued {
+			logger.L.Info("Job queued for execution", "job_id", run.Job.ID, "execution_id", execution.ID, "trigger", execution.Trigger)
+		}
+		return execution, err
+	}
+
+	execution.Status = models.ExecutionStatusFailed
+	execution.Output = ErrQueueFull.Error()
+	execution.FinishedAt = time.Now()
+	db.Create(&execution)
+	if execution.RunID == 0 {
+		execution.RunID = execution.ID
+		db.Model(&execution).Update("run_id", execution.RunID)
+	}
+	db.Model(&run.Job).Update("last_status", execution.Status)
+	logger.L.Warn("Job queue is full. Run dropped.", "job_id", run.Job.ID, "execution_id", execution.ID)
+	return execution, ErrQueueFull
+}
+
+// WaitExecution waits up to timeout for an execution queued by this process
+// to finish, and reports whether it has. Another instance may run it, so
+// the database is checked as well.
+func WaitExecution(db *gorm.DB, executionID uint, timeout time.Duration) bool {
+	finishedMu.Lock()
+	done, ok := finished[executionID]
+	finishedMu.Unlock()
+	if !ok {
+		return true
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	ticker := time.NewTicker(queuePollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return true
+		case <-timer.C:
+			return false
+		case <-ticker.C:
+			if isFinished(db, executionID) {
+				notifyFinished(executionID)
+				return true
+			}
+		}
+	}
+}
+
+// isFinished reports whether the execution is over.
+func isFinished(db *gorm.DB, executionID uint) bool {
+	var execution models.JobExecution
+	result := db.Select("id", "status").Where("id = ?", executionID).Limit(1).Find(&execution)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false
+	}
+	return execution.Status != models.ExecutionStatusQueued && execution.Status != models.ExecutionStatusRunning
+}
+
+// pruneFinished forgets the executions queued by this process that another
+// instance has finished.
+func pruneFinished(db *gorm.DB) {
+	finishedMu.Lock()
+	ids := make([]uint, 0, len(finished))
+	for executionID := range finished {
+		ids = append(ids, executionID)
+	}
+	finishedMu.Unlock()
+
+	if len(ids) == 0 {
+		return
+	}
+
+	// Executions not committed yet are not found, and left alone.
+	var over []uint
+	err := db.Model(&models.JobExecution{}).
+		Where("id IN ? AND status NOT IN ?", ids, []string{models.ExecutionStatusQueued, models.ExecutionStatusRunning}).
+		Pluck("id", &over).Error
+	if err != nil {
+		logger.L.Error("Failed to look for finished executions", "error", err)
+		return
+	}
+	for _, executionID := range over {
+		notifyFinished(executionID)
+	}
+}
+
+func notifyFinished(executionID uint) {
+	finishedMu.Lock()
+	if done, ok := finished[executionID]; ok {
+		close(done)
+		delete(finished, executionID)
+	}
+	finishedMu.Unlock()
+}
+
+// CancelQueued cancels an execution that is still waiting in the queue. It
+// returns ErrExecutionNotRunning if the execution is no longer queued.
+func CancelQueued(db *gorm.DB, executionID uint, username string) error {
+	result := db.Model(&models.JobExecution{}).
+		Where("id = ? AND status = ?", executionID, models.ExecutionStatusQueued).
+		Updates(map[string]interface{}{
+			"status":       models.ExecutionStatusCancelled,
+			"cancelled_by": username,
+			"finished_at":  time.Now(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrExecutionNotRunning
+	}
+
+	db.Model(&models.QueueItem{}).
+		Where("execution_id = ? AND state = ?", executionID, models.QueueStateEnqueued).
+		Updates(map[string]interface{}{"state": models.QueueStateDone, "finished_at": time.Now()})
+
+	var execution models.JobExecution
+	if db.First(&execution, executionID).Error == nil {
+		db.Model(&models.Job{}).Where("id = ?", execution.JobID).Update("last_status", models.ExecutionStatusCancelled)
+	}
+	notifyFinished(executionID)
+	logger.L.Info("Cancelled queued execution", "execution_id", executionID, "user", username)
+	return nil
+}
+
+// saveExecution stores the record of an attempt that starts now. A run taken
+// from the queue fills in its queued execution instead of adding one, but
+// only while that is still queued: it returns false, and leaves the
+// execution as it is, if it was cancelled in the meantime. Under a policy
+// that lets one run of the job execute at a time, it also returns false if
+// another instance started a run of the job since admit.
+func saveExecution(db *gorm.DB, run Run, execution *models.JobExecution) bool {
+	saved := false
+	if run.ExecutionID != 0 {
+		var queued models.JobExecution
+		if err := db.First(&queued, run.ExecutionID).Error; err == nil {
+			execution.ID = queued.ID
+			execution.CreatedAt = queued.CreatedAt
+			update := db.Model(execution).Where("status = ?", models.ExecutionStatusQueued)
+			if execution.Status == models.ExecutionStatusRunning && run.Job.ConcurrencyPolicy != "" && run.Job.ConcurrencyPolicy != models.ConcurrencyPolicyAllow {
+				update = update.Where("NOT EXISTS (SELECT 1 FROM job_executions AS other WHERE other.job_id = ? AND other.status = ? AND other.id <> ?)",
+					run.Job.ID, models.ExecutionStatusRunning, queued.ID)
+			}
+			result := update.Select("*").Updates(execution)
+			if result.Error != nil {
+				logger.L.Error("Failed to save job execution history", "job_id", run.Job.ID, "error", result.Error)
+			} else if result.RowsAffected == 0 {
+				return false
+			}
+			saved = true
+		}
+	}
+	if !saved {
+		if result := db.Save(execution); result.Error != nil {
+			logger.L.Error("Failed to save job execution history", "job_id", run.Job.ID, "error", result.Error)
+		}
+	}
+	if execution.RunID == 0 {
+		execution.RunID = execution.ID
+		db.Model(execution).Update("run_id", execution.RunID)
+	}
+	return true
+}
+
+// stillQueued reports whether the execution queued for a run is waiting to
+// start, rather than having been cancelled in the meantime.
+func stillQueued(db *gorm.DB, run Run) (models.JobExecution, bool) {
+	var execution models.JobExecution
+	if run.ExecutionID == 0 {
+		return execution, true
+	}
+	if err := db.First(&execution, run.ExecutionID).Error; err != nil {
+		return execution, false
+	}
+	return execution, execution.Status == models.ExecutionStatusQueued
+}
+
+// dropRun gives up on a run whose queued execution is no longer queued, and
+// returns the execution as it is recorded.
+func dropRun(run Run, execution models.JobExecution) models.JobExecution {
+	logger.L.Info("Dropping run whose execution is no longer queued", "job_id", run.Job.ID, "execution_id", run.ExecutionID, "status", execution.Status)
+	notifyFinished(run.ExecutionID)
+	return execution
+}
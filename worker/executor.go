@@ -0,0 +1,131 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"jobScheduler/models"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Executor runs the jobs of one type. Each executor decides the format of
+// the Spec of its jobs.
+type Executor interface {
+	// Validate checks that a job has everything the executor needs to run
+	// it. It is called before the job is saved.
+	Validate(job models.Job) error
+	// Execute runs one attempt of a job until it finishes or ctx is done.
+	// Output is written to attempt.Stdout and attempt.Stderr as it is
+	// produced; any output returned is added to stdout at the end.
+	// Executors may record results of their own on attempt.Execution.
+	Execute(ctx context.Context, attempt *Attempt) (string, error)
+}
+
+// timeoutSetter is implemented by executors whose spec can set the timeout
+// of a job in place of TimeoutSeconds.
+type timeoutSetter interface {
+	Timeout(job models.Job) (time.Duration, bool)
+}
+
+// Attempt is one attempt of a job handed to an Executor.
+type Attempt struct {
+	Job       models.Job
+	Execution *models.JobExecution
+	// Secrets holds the decrypted values of the job's secrets by name.
+	// Their values are redacted from the output.
+	Secrets map[string]string
+	// Stdout and Stderr receive the attempt's output. Secret values are
+	// redacted from both.
+	Stdout  io.Writer
+	Stderr  io.Writer
+	started func(pid int)
+}
+
+// Started reports the process ID of the attempt once it is running.
+func (a *Attempt) Started(pid int) {
+	if a.started != nil {
+		a.started(pid)
+	}
+}
+
+var (
+	executorsMu sync.RWMutex
+	executors   = map[string]Executor{
+		models.JobTypeCommand: commandExecutor{},
+		models.JobTypeHTTP:    httpExecutor{},
+	}
+)
+
+// RegisterExecutor makes jobs of jobType run through executor, replacing
+// any executor registered for it before.
+func RegisterExecutor(jobType string, executor Executor) {
+	executorsMu.Lock()
+	executors[jobType] = executor
+	executorsMu.Unlock()
+}
+
+// JobTypes returns the job types that have an executor.
+func JobTypes() []string {
+	executorsMu.RLock()
+	defer executorsMu.RUnlock()
+	types := make([]string, 0, len(executors))
+	for jobType := range executors {
+		types = append(types, jobType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// executorFor returns the executor of the job's Type, where an empty Type
+// means a command job.
+func executorFor(job models.Job) (Executor, error) {
+	jobType := job.Type
+	if jobType == "" {
+		jobType = models.JobTypeCommand
+	}
+	executorsMu.RLock()
+	executor, ok := executors[jobType]
+	executorsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("invalid job type %q: must be one of %s", job.Type, strings.Join(JobTypes(), ", "))
+	}
+	return executor, nil
+}
+
+// ValidateJobSpec checks the job with the executor of its type, and its
+// command template if it has one. The template must render without params,
+// since scheduled runs have none.
+func ValidateJobSpec(job models.Job) error {
+	executor, err := executorFor(job)
+	if err != nil {
+		return err
+	}
+	if isTemplated(job.Command) {
+		if _, err := parseCommandTemplate(job.Command); err != nil {
+			return err
+		}
+		if _, err := RenderCommand(job, nil, time.Now()); err != nil {
+			return fmt.Errorf("%v; scheduled runs have no params, so give optional ones a default with or/index", err)
+		}
+	}
+	return executor.Validate(job)
+}
+
+// DecodeSpec decodes a job's Spec into spec, rejecting unknown fields so
+// that typos do not go unnoticed. An empty Spec leaves spec unchanged.
+func DecodeSpec(job models.Job, spec any) error {
+	if job.Spec.IsEmpty() {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(job.Spec))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(spec); err != nil {
+		return fmt.Errorf("invalid %s spec: %v", job.Type, err)
+	}
+	return nil
+}
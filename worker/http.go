@@ -0,0 +1,278 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"jobScheduler/models"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxResponseBytes is how much of a response body is stored on the
+// execution. Assertions are checked against up to maxAssertedBytes.
+const (
+	maxResponseBytes = 4 << 10
+	maxAssertedBytes = 1 << 20
+)
+
+// HTTPResult describes the response to an http job's request.
+type HTTPResult struct {
+	StatusCode int
+	Latency    time.Duration
+	Response   string // truncated to maxResponseBytes
+}
+
+// httpExecutor makes the request described by an HTTPSpec.
+type httpExecutor struct{}
+
+func (httpExecutor) Validate(job models.Job) error {
+	if job.Spec.IsEmpty() {
+		return errors.New("http jobs require a spec with a url")
+	}
+	var spec models.HTTPSpec
+	if err := DecodeSpec(job, &spec); err != nil {
+		return err
+	}
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+	for _, name := range spec.SecretReferences() {
+		if !slices.Contains(job.Secrets, name) {
+			return fmt.Errorf("secret %s is used in the spec but not listed in the job's secrets", name)
+		}
+	}
+	return nil
+}
+
+func (httpExecutor) Execute(ctx context.Context, attempt *Attempt) (string, error) {
+	var spec models.HTTPSpec
+	if err := DecodeSpec(attempt.Job, &spec); err != nil {
+		return "", err
+	}
+	if len(attempt.Secrets) > 0 {
+		spec.URL = expandSecrets(spec.URL, attempt.Secrets)
+		spec.Body = expandSecrets(spec.Body, attempt.Secrets)
+		headers := make(map[string]string, len(spec.Headers))
+		for name, value := range spec.Headers {
+			headers[name] = expandSecrets(value, attempt.Secrets)
+		}
+		spec.Headers = headers
+		if spec.TLS != nil {
+			spec.TLS.ClientKey = expandSecrets(spec.TLS.ClientKey, attempt.Secrets)
+		}
+	}
+	return runHTTP(ctx, attempt, &spec)
+}
+
+// expandSecrets replaces every ${NAME} in s naming one of the secrets with
+// its value. Other text is left alone.
+func expandSecrets(s string, secrets map[string]string) string {
+	for name, value := range secrets {
+		s = strings.ReplaceAll(s, "${"+name+"}", value)
+	}
+	return s
+}
+
+// Timeout returns the timeout set in the job's spec.
+func (httpExecutor) Timeout(job models.Job) (time.Duration, bool) {
+	var spec models.HTTPSpec
+	if DecodeSpec(job, &spec) != nil || spec.Timeout == "" {
+		return 0, false
+	}
+	timeout, err := time.ParseDuration(spec.Timeout)
+	return timeout, err == nil
+}
+
+// runHTTP makes the request and records the response on the attempt's execution.
+func runHTTP(ctx context.Context, attempt *Attempt, spec *models.HTTPSpec) (string, error) {
+	result, output, err := ExecuteHTTP(ctx, spec)
+	attempt.Execution.StatusCode = result.StatusCode
+	attempt.Execution.LatencyMs = result.Latency.Milliseconds()
+	attempt.Execution.Response = result.Response
+	return output, err
+}
+
+// ExecuteHTTP makes the request described by spec and checks the response
+// against the expected status codes and assertions. The output summarises
+// the exchange; the error reports why the execution failed.
+func ExecuteHTTP(ctx context.Context, spec *models.HTTPSpec) (HTTPResult, string, error) {
+	var result HTTPResult
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if spec.TLS != nil {
+		config, err := spec.TLS.Config()
+		if err != nil {
+			return result, "", err
+		}
+		transport.TLSClientConfig = config
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport}
+
+	var body io.Reader
+	if spec.Body != "" {
+		body = strings.NewReader(spec.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, spec.RequestMethod(), spec.URL, body)
+	if err != nil {
+		return result, "", err
+	}
+	for name, value := range spec.Headers {
+		req.Header.Set(name, value)
+	}
+	if host := req.Header.Get("Host"); host != "" {
+		req.Host = host
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		result.Latency = time.Since(start)
+		return result, fmt.Sprintf("%s %s failed after %dms: %v\n", req.Method, spec.URL, result.Latency.Milliseconds(), err), err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxAssertedBytes))
+	result.Latency = time.Since(start)
+	result.StatusCode = resp.StatusCode
+	result.Response = string(data)
+	if len(data) > maxResponseBytes {
+		result.Response = string(data[:maxResponseBytes])
+	}
+
+	var output strings.Builder
+	fmt.Fprintf(&output, "%s %s -> %s in %dms\n", req.Method, spec.URL, resp.Status, result.Latency.Milliseconds())
+	if err != nil {
+		fmt.Fprintf(&output, "reading response failed: %v\n", err)
+		return result, output.String(), err
+	}
+
+	if !spec.ExpectsStatus(resp.StatusCode) {
+		err = fmt.Errorf("unexpected status %d", resp.StatusCode)
+		fmt.Fprintf(&output, "%v\n", err)
+		return result, output.String(), err
+	}
+
+	var failed []string
+	for _, assertion := range spec.Assertions {
+		if msg := checkAssertion(assertion, data); msg != "" {
+			failed = append(failed, msg)
+			fmt.Fprintf(&output, "assertion failed: %s\n", msg)
+		}
+	}
+	if len(failed) > 0 {
+		return result, output.String(), fmt.Errorf("%d of %d assertions failed", len(failed), len(spec.Assertions))
+	}
+	return result, output.String(), nil
+}
+
+// checkAssertion returns why body does not satisfy the assertion, or "" if
+// it does.
+func checkAssertion(assertion models.HTTPAssertion, body []byte) string {
+	switch assertion.Type {
+	case models.AssertionContains:
+		if !strings.Contains(string(body), assertion.Value) {
+			return fmt.Sprintf("body does not contain %q", assertion.Value)
+		}
+	case models.AssertionRegex:
+		re, err := regexp.Compile(assertion.Value)
+		if err != nil {
+			return fmt.Sprintf("invalid regex %q: %v", assertion.Value, err)
+		}
+		if !re.Match(body) {
+			return fmt.Sprintf("body does not match %q", assertion.Value)
+		}
+	case models.AssertionJSONPath:
+		var doc any
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return fmt.Sprintf("body is not valid JSON: %v", err)
+		}
+		value, err := lookupJSONPath(doc, assertion.Path)
+		if err != nil {
+			return fmt.Sprintf("%s: %v", assertion.Path, err)
+		}
+		if assertion.Value != "" && formatJSONValue(value) != assertion.Value {
+			return fmt.Sprintf("%s is %s, want %s", assertion.Path, formatJSONValue(value), assertion.Value)
+		}
+	default:
+		return fmt.Sprintf("unknown assertion type %q", assertion.Type)
+	}
+	return ""
+}
+
+// lookupJSONPath returns the value at path in doc. Paths use dots for object
+// keys and brackets for array indexes, optionally starting with "$", e.g.
+// "$.items[0].name" or "data['key with dots.'].id".
+func lookupJSONPath(doc any, path string) (any, error) {
+	path = strings.TrimPrefix(path, "$")
+	current := doc
+	for path != "" {
+		var key string
+		index := -1
+		switch path[0] {
+		case '.':
+			path = path[1:]
+			continue
+		case '[':
+			end := strings.IndexByte(path, ']')
+			if end < 0 {
+				return nil, errors.New("unterminated [")
+			}
+			inner := path[1:end]
+			path = path[end+1:]
+			if unquoted, ok := strings.CutPrefix(inner, "'"); ok {
+				key = strings.TrimSuffix(unquoted, "'")
+			} else if unquoted, ok := strings.CutPrefix(inner, `"`); ok {
+				key = strings.TrimSuffix(unquoted, `"`)
+			} else {
+				n, err := strconv.Atoi(inner)
+				if err != nil || n < 0 {
+					return nil, fmt.Errorf("invalid index %q", inner)
+				}
+				index = n
+			}
+		default:
+			end := strings.IndexAny(path, ".[")
+			if end < 0 {
+				end = len(path)
+			}
+			key, path = path[:end], path[end:]
+		}
+
+		if index >= 0 {
+			array, ok := current.([]any)
+			if !ok || index >= len(array) {
+				return nil, fmt.Errorf("no element %d", index)
+			}
+			current = array[index]
+			continue
+		}
+		object, ok := current.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("no key %q", key)
+		}
+		value, ok := object[key]
+		if !ok {
+			return nil, fmt.Errorf("no key %q", key)
+		}
+		current = value
+	}
+	return current, nil
+}
+
+// formatJSONValue renders a value for comparison with an assertion's Value:
+// strings as they are, everything else as JSON.
+func formatJSONValue(value any) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
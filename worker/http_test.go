@@ -0,0 +1,344 @@
+package worker
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"jobScheduler/models"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecuteHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"status": "healthy", "version": "2.4.1", "checks": [{"name": "db", "status": "ok"}], "count": 3}`)
+		case "/missing":
+			http.Error(w, "not found", http.StatusNotFound)
+		case "/echo":
+			body, _ := io.ReadAll(r.Body)
+			json.NewEncoder(w).Encode(map[string]string{
+				"method":        r.Method,
+				"body":          string(body),
+				"authorization": r.Header.Get("Authorization"),
+				"host":          r.Host,
+			})
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		spec       models.HTTPSpec
+		wantStatus int
+		wantErr    string // substring of the error, "" for success
+		wantOutput string // substring of the output
+	}{
+		{
+			name:       "2xx succeeds by default",
+			spec:       models.HTTPSpec{URL: server.URL + "/health"},
+			wantStatus: 200,
+			wantOutput: "GET " + server.URL + "/health -> 200 OK",
+		},
+		{
+			name:       "unexpected status fails",
+			spec:       models.HTTPSpec{URL: server.URL + "/missing"},
+			wantStatus: 404,
+			wantErr:    "unexpected status 404",
+		},
+		{
+			name:       "expected status overrides 2xx",
+			spec:       models.HTTPSpec{URL: server.URL + "/missing", ExpectedStatus: []int{404}},
+			wantStatus: 404,
+		},
+		{
+			name:       "expected status rejects other 2xx",
+			spec:       models.HTTPSpec{URL: server.URL + "/health", ExpectedStatus: []int{204}},
+			wantStatus: 200,
+			wantErr:    "unexpected status 200",
+		},
+		{
+			name: "passing assertions",
+			spec: models.HTTPSpec{URL: server.URL + "/health", Assertions: []models.HTTPAssertion{
+				{Type: models.AssertionContains, Value: "healthy"},
+				{Type: models.AssertionRegex, Value: `"version":\s*"2\.`},
+				{Type: models.AssertionJSONPath, Path: "$.checks[0].status", Value: "ok"},
+				{Type: models.AssertionJSONPath, Path: "count", Value: "3"},
+				{Type: models.AssertionJSONPath, Path: "checks[0]['name']"},
+			}},
+			wantStatus: 200,
+		},
+		{
+			name: "failing assertions are all reported",
+			spec: models.HTTPSpec{URL: server.URL + "/health", Assertions: []models.HTTPAssertion{
+				{Type: models.AssertionContains, Value: "unhealthy"},
+				{Type: models.AssertionRegex, Value: `"version":\s*"3\.`},
+				{Type: models.AssertionJSONPath, Path: "checks[0].status", Value: "down"},
+				{Type: models.AssertionJSONPath, Path: "checks[1]"},
+			}},
+			wantStatus: 200,
+			wantErr:    "4 of 4 assertions failed",
+			wantOutput: "checks[0].status is ok, want down",
+		},
+		{
+			name: "method, headers and body are sent",
+			spec: models.HTTPSpec{
+				Method:  "post",
+				URL:     server.URL + "/echo",
+				Headers: map[string]string{"Authorization": "Bearer abc", "Host": "api.example.com"},
+				Body:    "payload",
+				Assertions: []models.HTTPAssertion{
+					{Type: models.AssertionJSONPath, Path: "method", Value: "POST"},
+					{Type: models.AssertionJSONPath, Path: "body", Value: "payload"},
+					{Type: models.AssertionJSONPath, Path: "authorization", Value: "Bearer abc"},
+					{Type: models.AssertionJSONPath, Path: "host", Value: "api.example.com"},
+				},
+			},
+			wantStatus: 200,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, output, err := ExecuteHTTP(context.Background(), &tt.spec)
+			if result.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", result.StatusCode, tt.wantStatus)
+			}
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("error = %v, want none", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+			if !strings.Contains(output, tt.wantOutput) {
+				t.Errorf("output = %q, want it to contain %q", output, tt.wantOutput)
+			}
+		})
+	}
+}
+
+func TestExecuteHTTPTruncatesResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strings.Repeat("x", maxResponseBytes*2)+"end")
+	}))
+	defer server.Close()
+
+	spec := models.HTTPSpec{URL: server.URL, Assertions: []models.HTTPAssertion{{Type: models.AssertionContains, Value: "end"}}}
+	result, _, err := ExecuteHTTP(context.Background(), &spec)
+	if err != nil {
+		t.Fatalf("error = %v; assertions must see more than the stored response", err)
+	}
+	if len(result.Response) != maxResponseBytes {
+		t.Errorf("stored %d bytes of the response, want %d", len(result.Response), maxResponseBytes)
+	}
+}
+
+func TestExecuteHTTPTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	spec := models.HTTPSpec{URL: server.URL}
+	result, output, err := ExecuteHTTP(ctx, &spec)
+	if err == nil {
+		t.Fatal("expected the request to time out")
+	}
+	if result.StatusCode != 0 || !strings.Contains(output, "failed after") {
+		t.Errorf("status = %d, output = %q", result.StatusCode, output)
+	}
+}
+
+func TestHTTPExecutorRecordsResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer s3cret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "created")
+	}))
+	defer server.Close()
+
+	job := models.Job{
+		Type:    models.JobTypeHTTP,
+		Secrets: []string{"API_TOKEN"},
+		Spec:    models.JobSpec(`{"method": "POST", "url": "` + server.URL + `", "headers": {"Authorization": "Bearer ${API_TOKEN}"}}`),
+	}
+	if err := (httpExecutor{}).Validate(job); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	execution := models.JobExecution{}
+	attempt := &Attempt{Job: job, Execution: &execution, Secrets: map[string]string{"API_TOKEN": "s3cret"}}
+	if _, err := (httpExecutor{}).Execute(context.Background(), attempt); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if execution.StatusCode != http.StatusCreated || execution.Response != "created" || execution.LatencyMs < 0 {
+		t.Errorf("execution records status %d, response %q, latency %dms", execution.StatusCode, execution.Response, execution.LatencyMs)
+	}
+}
+
+func TestHTTPExecutorClientCertificate(t *testing.T) {
+	certPEM, keyPEM := selfSignedCert(t)
+	clientCA := x509.NewCertPool()
+	clientCA.AppendCertsFromPEM(certPEM)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello "+r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCA}
+	server.StartTLS()
+	defer server.Close()
+	serverCA := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+
+	spec, _ := json.Marshal(models.HTTPSpec{
+		URL: server.URL,
+		TLS: &models.HTTPTLS{CACert: serverCA, ClientCert: string(certPEM), ClientKey: "${CLIENT_KEY}"},
+		Assertions: []models.HTTPAssertion{
+			{Type: models.AssertionContains, Value: "hello dispatch-test"},
+		},
+	})
+	job := models.Job{Type: models.JobTypeHTTP, Secrets: []string{"CLIENT_KEY"}, Spec: models.JobSpec(spec)}
+	if err := (httpExecutor{}).Validate(job); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	execution := models.JobExecution{}
+	attempt := &Attempt{Job: job, Execution: &execution, Secrets: map[string]string{"CLIENT_KEY": string(keyPEM)}}
+	if output, err := (httpExecutor{}).Execute(context.Background(), attempt); err != nil {
+		t.Fatalf("Execute: %v\n%s", err, output)
+	}
+
+	// Without the client certificate the handshake fails.
+	var plain models.HTTPSpec
+	json.Unmarshal(spec, &plain)
+	plain.TLS.ClientCert, plain.TLS.ClientKey = "", ""
+	if _, _, err := ExecuteHTTP(context.Background(), &plain); err == nil {
+		t.Error("request without a client certificate succeeded")
+	}
+}
+
+func TestHTTPExecutorValidate(t *testing.T) {
+	certPEM, keyPEM := selfSignedCert(t)
+	certJSON, _ := json.Marshal(string(certPEM))
+	keyJSON, _ := json.Marshal(string(keyPEM))
+
+	tests := []struct {
+		name    string
+		secrets []string
+		spec    string
+		wantErr string // substring of the error, "" for a valid spec
+	}{
+		{name: "minimal", spec: `{"url": "https://example.com"}`},
+		{name: "missing spec", spec: ``, wantErr: "require a spec"},
+		{name: "relative url", spec: `{"url": "/health"}`, wantErr: "invalid http url"},
+		{name: "unknown field", spec: `{"url": "https://example.com", "verb": "GET"}`, wantErr: "unknown field"},
+		{name: "bad method", spec: `{"url": "https://example.com", "method": "FETCH"}`, wantErr: "invalid http method"},
+		{name: "bad timeout", spec: `{"url": "https://example.com", "timeout": "-1s"}`, wantErr: "must be positive"},
+		{name: "bad status", spec: `{"url": "https://example.com", "expectedStatus": [42]}`, wantErr: "invalid expected status"},
+		{name: "bad regex", spec: `{"url": "https://example.com", "assertions": [{"type": "regex", "value": "("}]}`, wantErr: "invalid regex"},
+		{name: "plain header", spec: `{"url": "https://example.com", "headers": {"Accept": "application/json"}}`},
+		{
+			name:    "credential header from a secret",
+			secrets: []string{"API_TOKEN"},
+			spec:    `{"url": "https://example.com", "headers": {"Authorization": "Bearer ${API_TOKEN}"}}`,
+		},
+		{
+			name:    "literal credential header",
+			spec:    `{"url": "https://example.com", "headers": {"Authorization": "Bearer abc"}}`,
+			wantErr: "must use a secret",
+		},
+		{
+			name:    "literal api key header",
+			spec:    `{"url": "https://example.com", "headers": {"X-API-Key": "abc"}}`,
+			wantErr: "must use a secret",
+		},
+		{
+			name:    "credential header from an unlisted secret",
+			spec:    `{"url": "https://example.com", "headers": {"Authorization": "Bearer ${API_TOKEN}"}}`,
+			wantErr: "secret API_TOKEN is used in the spec but not listed",
+		},
+		{
+			name:    "client key from a secret",
+			secrets: []string{"CLIENT_KEY"},
+			spec:    `{"url": "https://example.com", "tls": {"clientCert": ` + string(certJSON) + `, "clientKey": "${CLIENT_KEY}"}}`,
+		},
+		{
+			name:    "literal client key",
+			spec:    `{"url": "https://example.com", "tls": {"clientCert": ` + string(certJSON) + `, "clientKey": ` + string(keyJSON) + `}}`,
+			wantErr: "must name a secret",
+		},
+		{
+			name:    "client certificate without a key",
+			spec:    `{"url": "https://example.com", "tls": {"clientCert": ` + string(certJSON) + `}}`,
+			wantErr: "go together",
+		},
+		{
+			name:    "bad ca certificate",
+			spec:    `{"url": "https://example.com", "tls": {"caCert": "nope"}}`,
+			wantErr: "invalid tls caCert",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := models.Job{Type: models.JobTypeHTTP, Secrets: tt.secrets, Spec: models.JobSpec(tt.spec)}
+			err := (httpExecutor{}).Validate(job)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("Validate = %v, want no error", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Validate = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// selfSignedCert returns a PEM encoded client certificate and its key.
+func selfSignedCert(t *testing.T) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "dispatch-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM
+}
@@ -0,0 +1,121 @@
+package worker
+
+import (
+	"jobScheduler/logger"
+	"jobScheduler/models"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Every instance sharing the database records itself in the instances table
+// and sends a heartbeat every instanceHeartbeatInterval. Requests about a run
+// another instance holds, such as its log stream or the reports of the agent
+// executing it, are forwarded to the URL that instance recorded.
+const (
+	instanceHeartbeatInterval = 5 * time.Second
+	// instanceTimeout is how long an instance is regarded as alive after
+	// its last heartbeat.
+	instanceTimeout = 3 * instanceHeartbeatInterval
+	// instanceRetention is how long instances that stopped sending
+	// heartbeats are kept.
+	instanceRetention = time.Hour
+)
+
+// unregistered is set once the process shuts down; it no longer sends
+// heartbeats.
+var unregistered atomic.Bool
+
+// InstanceID returns the ID of this instance, as host:pid.
+func InstanceID() string {
+	return instanceID
+}
+
+// startInstanceHeartbeat records this instance, reachable at url, and keeps
+// its record fresh for as long as the process runs.
+func startInstanceHeartbeat(db *gorm.DB, url string) {
+	instance := models.Instance{
+		ID:              instanceID,
+		URL:             url,
+		Labels:          workerLabels,
+		Queues:          servedQueues(),
+		StartedAt:       time.Now(),
+		LastHeartbeatAt: time.Now(),
+	}
+	if err := db.Save(&instance).Error; err != nil {
+		logger.L.Error("Failed to record this instance", "instance", instanceID, "error", err)
+	}
+	logger.L.Info("Instance recorded", "instance", instanceID, "url", url)
+
+	go func() {
+		ticker := time.NewTicker(instanceHeartbeatInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			if unregistered.Load() {
+				return
+			}
+			instance.LastHeartbeatAt = now
+			// Saving the whole record brings it back if it was removed
+			// while this instance could not reach the database.
+			if err := db.Save(&instance).Error; err != nil {
+				logger.L.Error("Failed to send instance heartbeat", "instance", instanceID, "error", err)
+			}
+		}
+	}()
+}
+
+// servedQueues returns the queues that have workers in this process.
+func servedQueues() []string {
+	names := []string{}
+	for name, q := range queues {
+		if q.workers > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// InstanceURL returns the URL of the instance with the given ID if it is
+// another instance that is alive. Requests about its runs are forwarded
+// there.
+func InstanceURL(db *gorm.DB, id string) (string, bool) {
+	if id == "" || id == instanceID {
+		return "", false
+	}
+	var instance models.Instance
+	result := db.Where("id = ? AND last_heartbeat_at >= ?", id, time.Now().Add(-instanceTimeout)).
+		Limit(1).
+		Find(&instance)
+	if result.Error != nil || result.RowsAffected == 0 || instance.URL == "" {
+		return "", false
+	}
+	return instance.URL, true
+}
+
+// otherInstances returns the other instances that are alive.
+func otherInstances(db *gorm.DB) ([]models.Instance, error) {
+	var instances []models.Instance
+	err := db.Where("id <> ? AND last_heartbeat_at >= ?", instanceID, time.Now().Add(-instanceTimeout)).
+		Find(&instances).Error
+	return instances, err
+}
+
+// UnregisterInstance removes the record of this instance when the process
+// shuts down, so that nothing is forwarded to it any more.
+func UnregisterInstance(db *gorm.DB) {
+	unregistered.Store(true)
+	if err := db.Delete(&models.Instance{ID: instanceID}).Error; err != nil {
+		logger.L.Error("Failed to remove the record of this instance", "instance", instanceID, "error", err)
+	}
+}
+
+// removeOldInstances drops instances that have not been seen for
+// instanceRetention.
+func removeOldInstances(db *gorm.DB, now time.Time) {
+	if err := db.Where("last_heartbeat_at < ?", now.Add(-instanceRetention)).Delete(&models.Instance{}).Error; err != nil {
+		logger.L.Error("Failed to remove old instances", "error", err)
+	}
+}
@@ -0,0 +1,191 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"jobScheduler/logger"
+	"jobScheduler/models"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// HostnameLabel is set on every worker and agent to the name of its host,
+// so that a job can be pinned to a machine.
+const HostnameLabel = "hostname"
+
+// unschedulableAfter is how long a queued run may go without any worker
+// that could take it before it is given up as unschedulable. It lets agents
+// restart without losing the runs waiting for them.
+const unschedulableAfter = leaseDuration
+
+// leaseScanBatch is how many queued items are read at a time while looking
+// for one whose node selector matches the worker.
+const leaseScanBatch = 100
+
+// workerLabels are the labels of the workers of this process.
+var workerLabels = map[string]string{HostnameLabel: hostname}
+
+// setWorkerLabels sets the labels of the workers of this process. The
+// hostname label defaults to the name of the host.
+func setWorkerLabels(labels map[string]string) {
+	workerLabels = withHostname(labels, hostname)
+}
+
+// withHostname returns labels with the hostname label set, unless they set
+// it themselves.
+func withHostname(labels map[string]string, host string) map[string]string {
+	result := make(map[string]string, len(labels)+1)
+	for key, value := range labels {
+		result[key] = value
+	}
+	if _, ok := result[HostnameLabel]; !ok && host != "" {
+		result[HostnameLabel] = host
+	}
+	return result
+}
+
+// matchesSelector reports whether labels have every key of selector with
+// the same value. An empty selector matches every worker.
+func matchesSelector(labels, selector map[string]string) bool {
+	for key, value := range selector {
+		if label, ok := labels[key]; !ok || label != value {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateNodeSelector checks the node selector of a job.
+func ValidateNodeSelector(selector map[string]string) error {
+	for key := range selector {
+		if strings.TrimSpace(key) == "" {
+			return errors.New("node selector keys must not be empty")
+		}
+	}
+	return nil
+}
+
+// hasEligibleWorker reports whether a worker of this process or another
+// instance, or an online agent, takes runs from queue and matches selector.
+func hasEligibleWorker(db *gorm.DB, queue string, selector map[string]string) (bool, error) {
+	if q, ok := queues[queue]; ok && q.workers > 0 && matchesSelector(workerLabels, selector) {
+		return true, nil
+	}
+
+	instances, err := otherInstances(db)
+	if err != nil {
+		return false, err
+	}
+	for _, instance := range instances {
+		if slices.Contains(instance.Queues, queue) && matchesSelector(instance.Labels, selector) {
+			return true, nil
+		}
+	}
+
+	var agents []models.Agent
+	if err := db.Where("last_heartbeat_at >= ?", time.Now().Add(-leaseDuration)).Find(&agents).Error; err != nil {
+		return false, err
+	}
+	for _, agent := range agents {
+		if slices.Contains(agent.Queues, queue) && matchesSelector(agent.Labels, selector) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// unschedulableReason explains why no worker can take a run.
+func unschedulableReason(queue string, selector map[string]string) string {
+	if len(selector) == 0 {
+		return fmt.Sprintf("no worker or agent takes runs from queue %s", queue)
+	}
+	return fmt.Sprintf("no worker or agent of queue %s matches node selector %s", queue, formatSelector(selector))
+}
+
+func formatSelector(selector map[string]string) string {
+	keys := make([]string, 0, len(selector))
+	for key := range selector {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+selector[key])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// recordUnschedulable records a run that no worker can take as an
+// unschedulable execution instead of queueing it.
+func recordUnschedulable(db *gorm.DB, run Run, execution models.JobExecution, reason string) (models.JobExecution, error) {
+	now := time.Now()
+	execution.Status = models.ExecutionStatusUnschedulable
+	execution.Output = reason
+	execution.FinishedAt = now
+	if err := db.Create(&execution).Error; err != nil {
+		return execution, err
+	}
+	if execution.RunID == 0 {
+		execution.RunID = execution.ID
+		db.Model(&execution).Update("run_id", execution.RunID)
+	}
+	db.Model(&models.Job{}).Where("id = ?", run.Job.ID).Update("last_status", models.ExecutionStatusUnschedulable)
+	logger.L.Warn("No worker can take the run", "job_id", run.Job.ID, "execution_id", execution.ID, "reason", reason)
+	return execution, nil
+}
+
+// reclaimUnschedulable gives up on queued runs that no worker has been able
+// to take for unschedulableAfter, for example because the only agent that
+// matched their node selector went away.
+func reclaimUnschedulable(db *gorm.DB, now time.Time) {
+	var items []models.QueueItem
+	err := db.Where("state = ? AND available_at < ?", models.QueueStateEnqueued, now.Add(-unschedulableAfter)).
+		Find(&items).Error
+	if err != nil {
+		logger.L.Error("Failed to read queued runs", "error", err)
+		return
+	}
+
+	eligible := make(map[string]bool)
+	for _, item := range items {
+		key := item.Queue + "\x00" + formatSelector(item.NodeSelector)
+		ok, seen := eligible[key]
+		if !seen {
+			ok, err = hasEligibleWorker(db, item.Queue, item.NodeSelector)
+			if err != nil {
+				logger.L.Error("Failed to look for eligible workers", "error", err)
+				return
+			}
+			eligible[key] = ok
+		}
+		if ok {
+			continue
+		}
+
+		result := db.Model(&models.QueueItem{}).
+			Where("id = ? AND state = ?", item.ID, models.QueueStateEnqueued).
+			Updates(map[string]interface{}{
+				"state":       models.QueueStateDone,
+				"finished_at": now,
+			})
+		if result.Error != nil || result.RowsAffected == 0 {
+			continue
+		}
+		reason := unschedulableReason(item.Queue, item.NodeSelector)
+		result = db.Model(&models.JobExecution{}).
+			Where("id = ? AND status = ?", item.ExecutionID, models.ExecutionStatusQueued).
+			Updates(map[string]interface{}{
+				"status":      models.ExecutionStatusUnschedulable,
+				"output":      reason,
+				"finished_at": now,
+			})
+		if result.Error == nil && result.RowsAffected == 1 {
+			db.Model(&models.Job{}).Where("id = ?", item.JobID).Update("last_status", models.ExecutionStatusUnschedulable)
+		}
+		notifyFinished(item.ExecutionID)
+		logger.L.Warn("Gave up on a queued run no worker can take", "job_id", item.JobID, "execution_id", item.ExecutionID, "reason", reason)
+	}
+}
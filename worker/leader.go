@@ -0,0 +1,175 @@
+package worker
+
+import (
+	"jobScheduler/logger"
+	"jobScheduler/models"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Several instances may share the database. They all serve the API and run
+// queued work, but only the one holding the scheduler leader lease runs the
+// scheduling loop, so that every fire time is queued once.
+//
+// The leader renews the lease every leaderRenewInterval for
+// leaderLeaseDuration. The others try to take it over as often, and succeed
+// once it has expired, so a leader that dies is replaced within
+// leaderLeaseDuration plus leaderRenewInterval.
+const (
+	leaderLeaseDuration = 10 * time.Second
+	leaderRenewInterval = 2 * time.Second
+)
+
+// leadership is what this instance knows about its own leadership.
+var leadership struct {
+	sync.Mutex
+	term uint
+	// until is when this instance stops regarding itself as leader unless
+	// it renews the lease. It is a renewal interval short of the expiry
+	// the others see, so that it steps down before they can take over.
+	until time.Time
+	// resigned is set once the process shuts down; it no longer campaigns.
+	resigned bool
+}
+
+// isLeader reports whether this instance holds the scheduler leader lease.
+func isLeader() bool {
+	leadership.Lock()
+	defer leadership.Unlock()
+	return leadership.term != 0 && time.Now().Before(leadership.until)
+}
+
+// LeaderStatus describes who runs the scheduling loop.
+type LeaderStatus struct {
+	Instance string `json:"instance"` // this instance
+	Leader   bool   `json:"leader"`   // whether this instance is the leader
+	models.LeaderLease
+}
+
+// GetLeaderStatus returns the scheduler leader lease as seen by this
+// instance.
+func GetLeaderStatus(db *gorm.DB) (LeaderStatus, error) {
+	status := LeaderStatus{Instance: instanceID, Leader: isLeader()}
+	err := db.Where("name = ?", models.SchedulerLeaderLease).Limit(1).Find(&status.LeaderLease).Error
+	return status, err
+}
+
+// startLeaderElection campaigns for the scheduler leader lease for as long
+// as the process runs, and starts or stops the scheduling loop as the lease
+// is won or lost.
+func startLeaderElection(db *gorm.DB) {
+	err := db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.LeaderLease{Name: models.SchedulerLeaderLease}).Error
+	if err != nil {
+		logger.L.Error("Failed to create the scheduler leader lease", "error", err)
+	}
+
+	campaign(db)
+	go func() {
+		ticker := time.NewTicker(leaderRenewInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			campaign(db)
+		}
+	}()
+}
+
+// campaign renews the lease if this instance holds it, and otherwise takes
+// it over if it has expired.
+func campaign(db *gorm.DB) {
+	now := time.Now()
+	leadership.Lock()
+	term, resigned := leadership.term, leadership.resigned
+	leadership.Unlock()
+	if resigned {
+		return
+	}
+
+	if term != 0 {
+		result := db.Model(&models.LeaderLease{}).
+			Where("name = ? AND holder = ? AND term = ?", models.SchedulerLeaderLease, instanceID, term).
+			Update("expires_at", now.Add(leaderLeaseDuration))
+		switch {
+		case result.Error != nil:
+			logger.L.Error("Failed to renew the scheduler leader lease", "term", term, "error", result.Error)
+			if !isLeader() {
+				stepDown(term, "the lease could not be renewed in time")
+			}
+		case result.RowsAffected == 0:
+			stepDown(term, "the lease was taken over")
+		default:
+			leadership.Lock()
+			leadership.until = now.Add(leaderLeaseDuration - leaderRenewInterval)
+			leadership.Unlock()
+		}
+		return
+	}
+
+	result := db.Model(&models.LeaderLease{}).
+		Where("name = ? AND (expires_at < ? OR holder = ?)", models.SchedulerLeaderLease, now, instanceID).
+		Updates(map[string]interface{}{
+			"holder":      instanceID,
+			"term":        gorm.Expr("term + 1"),
+			"acquired_at": now,
+			"expires_at":  now.Add(leaderLeaseDuration),
+		})
+	if result.Error != nil {
+		logger.L.Error("Failed to campaign for the scheduler leader lease", "error", result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		return
+	}
+
+	var lease models.LeaderLease
+	if err := db.Where("name = ?", models.SchedulerLeaderLease).First(&lease).Error; err != nil || lease.Holder != instanceID {
+		logger.L.Error("Failed to read the scheduler leader lease", "error", err)
+		return
+	}
+	leadership.Lock()
+	leadership.term = lease.Term
+	leadership.until = now.Add(leaderLeaseDuration - leaderRenewInterval)
+	leadership.Unlock()
+
+	logger.L.Info("Became scheduler leader", "instance", instanceID, "term", lease.Term)
+	startScheduler(db)
+}
+
+// stepDown stops the scheduling loop after this instance lost the lease of
+// term.
+func stepDown(term uint, reason string) {
+	leadership.Lock()
+	if leadership.term != term {
+		leadership.Unlock()
+		return
+	}
+	leadership.term = 0
+	leadership.Unlock()
+
+	stopScheduler()
+	logger.L.Warn("No longer scheduler leader", "instance", instanceID, "term", term, "reason", reason)
+}
+
+// ResignLeadership gives up the scheduler leader lease, if this instance
+// holds it, so that another instance takes over right away instead of
+// waiting for it to expire. It is called when the process shuts down.
+func ResignLeadership(db *gorm.DB) {
+	leadership.Lock()
+	term := leadership.term
+	leadership.resigned = true
+	leadership.Unlock()
+	if term == 0 {
+		return
+	}
+
+	stepDown(term, "shutting down")
+	err := db.Model(&models.LeaderLease{}).
+		Where("name = ? AND holder = ? AND term = ?", models.SchedulerLeaderLease, instanceID, term).
+		Update("expires_at", time.Time{}).Error
+	if err != nil {
+		logger.L.Error("Failed to resign the scheduler leader lease", "error", err)
+	}
+}
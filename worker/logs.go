@@ -0,0 +1,343 @@
+package worker
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"jobScheduler/logger"
+	"jobScheduler/models"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// logDir holds a gzipped log file with the full output of every execution.
+// Logs of executions that finished more than logRetention ago are removed;
+// zero keeps them forever.
+var (
+	logDir       string
+	logRetention time.Duration
+)
+
+// logFlushInterval is how long written output may wait before it is
+// flushed, so that the log of a running execution can be read.
+const logFlushInterval = time.Second
+
+// logMemberBytes is about how much output a gzip member of a log holds. A
+// read decompresses the log from the member holding its offset, so it skips
+// at most this much.
+const logMemberBytes = 256 << 10
+
+// MaxLogRead is the most ReadLog returns at once.
+const MaxLogRead = 1 << 20
+
+// logFile is the gzipped log of one execution: a series of gzip members,
+// with an index file next to it that records where each member after the
+// first starts.
+type logFile struct {
+	mu         sync.Mutex
+	path       string
+	file       *os.File
+	index      *os.File
+	gz         *gzip.Writer
+	size       int64       // of the output written
+	memberSize int64       // of the output in the current member
+	flushTimer *time.Timer // pending while written output is not flushed
+	closed     bool
+}
+
+// logMember is where a member of a log starts, in the output and in the
+// file.
+type logMember struct {
+	output int64
+	file   int64
+}
+
+func logIndexPath(path string) string {
+	return path + ".idx"
+}
+
+func createLogFile(executionID uint) (*logFile, error) {
+	path := filepath.Join(logDir, fmt.Sprintf("%d.log.gz", executionID))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	index, err := os.OpenFile(logIndexPath(path), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &logFile{path: path, file: file, index: index, gz: gzip.NewWriter(file)}, nil
+}
+
+func (l *logFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n, err := l.gz.Write(p)
+	l.size += int64(n)
+	l.memberSize += int64(n)
+	if err == nil && l.memberSize >= logMemberBytes {
+		err = l.endMember()
+	}
+	if err == nil && l.flushTimer == nil {
+		l.flushTimer = time.AfterFunc(logFlushInterval, l.timedFlush)
+	}
+	return n, err
+}
+
+// timedFlush makes the output written since the last flush readable, also
+// when nothing is written after it.
+func (l *logFile) timedFlush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.flushTimer = nil
+	if l.closed {
+		return
+	}
+	if err := l.gz.Flush(); err != nil {
+		logger.L.Error("Failed to flush execution log", "path", l.path, "error", err)
+	}
+}
+
+// endMember finishes the current gzip member and records where the next
+// one starts. It must be called with l.mu held.
+func (l *logFile) endMember() error {
+	if err := l.gz.Close(); err != nil {
+		return err
+	}
+	offset, err := l.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	var entry [16]byte
+	binary.BigEndian.PutUint64(entry[:8], uint64(l.size))
+	binary.BigEndian.PutUint64(entry[8:], uint64(offset))
+	if _, err := l.index.Write(entry[:]); err != nil {
+		return err
+	}
+	l.gz.Reset(l.file)
+	l.memberSize = 0
+	return nil
+}
+
+func (l *logFile) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	if l.flushTimer != nil {
+		l.flushTimer.Stop()
+	}
+	err := l.gz.Close()
+	for _, file := range []*os.File{l.file, l.index} {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// LogChunk is part of an execution log.
+type LogChunk struct {
+	Data   []byte
+	Offset int64 // of Data within the log
+	Size   int64 // of the whole log
+}
+
+// ReadLog reads up to limit bytes of the log at path, starting at offset, or
+// if tail is positive its last tail bytes. The log of a running execution is
+// read as far as it has been flushed. Only the member holding the start of
+// the chunk and those after it are decompressed, and the last one to find
+// the size of the log.
+func ReadLog(path string, offset, limit, tail int64) (LogChunk, error) {
+	var chunk LogChunk
+	if limit <= 0 || limit > MaxLogRead {
+		limit = MaxLogRead
+	}
+	if tail > MaxLogRead {
+		tail = MaxLogRead
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return chunk, err
+	}
+	defer file.Close()
+	members := logMembers(path)
+
+	last := members[len(members)-1]
+	flushed, err := readMember(file, last, last.output, -1)
+	if err != nil {
+		return chunk, err
+	}
+	chunk.Size = last.output + int64(len(flushed))
+
+	chunk.Offset = min(offset, chunk.Size)
+	if tail > 0 {
+		chunk.Offset = max(chunk.Size-tail, 0)
+		limit = tail
+	}
+	limit = min(limit, chunk.Size-chunk.Offset)
+	member := members[0]
+	for _, m := range members {
+		if m.output <= chunk.Offset {
+			member = m
+		}
+	}
+	if member == last {
+		chunk.Data = flushed[chunk.Offset-last.output:][:limit]
+		return chunk, nil
+	}
+	chunk.Data, err = readMember(file, member, chunk.Offset, limit)
+	return chunk, err
+}
+
+// logMembers returns where the members of the log at path start, as its
+// index records. A log without an index is read as a single member.
+func logMembers(path string) []logMember {
+	members := []logMember{{}}
+	index, _ := os.ReadFile(logIndexPath(path))
+	for ; len(index) >= 16; index = index[16:] {
+		members = append(members, logMember{
+			output: int64(binary.BigEndian.Uint64(index[:8])),
+			file:   int64(binary.BigEndian.Uint64(index[8:])),
+		})
+	}
+	return members
+}
+
+// readMember decompresses the log in file from member m on and returns up
+// to limit bytes of the output from offset, or all of them if limit is
+// negative. It stops at the end of what has been flushed.
+func readMember(file *os.File, m logMember, offset, limit int64) ([]byte, error) {
+	if _, err := file.Seek(m.file, io.SeekStart); err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			// Nothing has been flushed yet.
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer gz.Close()
+
+	var r io.Reader = gz
+	if limit >= 0 {
+		r = io.LimitReader(gz, limit)
+	}
+	if _, err := io.CopyN(io.Discard, gz, offset-m.output); err != nil {
+		return nil, flushedEnd(err)
+	}
+	data, err := io.ReadAll(r)
+	return data, flushedEnd(err)
+}
+
+// flushedEnd drops the error of reading past the data flushed to a log whose
+// last member is not finished yet.
+func flushedEnd(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil
+	}
+	return err
+}
+
+// ReadLogLines returns the lines of the log at path that follow the offset
+// after, from up to MaxLogRead bytes of it, split and numbered as they were
+// sent live. With after zero it returns the lines within the last MaxLogRead
+// bytes instead. The log does not say which stream a line came from.
+func ReadLogLines(path string, after int) ([]LogLine, error) {
+	var chunk LogChunk
+	var err error
+	if after > 0 {
+		chunk, err = ReadLog(path, int64(after), MaxLogRead, 0)
+	} else {
+		chunk, err = ReadLog(path, 0, 0, MaxLogRead)
+	}
+	if err != nil {
+		return nil, err
+	}
+	data := chunk.Data
+	offset := int(chunk.Offset)
+	if after <= 0 && offset > 0 {
+		// Skip the partial first line of the tail.
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			return nil, nil
+		}
+		data, offset = data[i+1:], offset+i+1
+	}
+
+	var lines []LogLine
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, '\n')
+		var text []byte
+		var next int
+		switch {
+		case end >= 0 && end <= maxLineBytes:
+			text, next = data[:end], end+1
+		case len(data) > maxLineBytes:
+			text, next = data[:maxLineBytes], maxLineBytes
+		case offset+len(data) == int(chunk.Size):
+			// The log ends within a line.
+			text, next = data, len(data)
+		default:
+			// The rest of the line is beyond this chunk.
+			return lines, nil
+		}
+		offset += next
+		lines = append(lines, LogLine{Seq: offset, Text: string(text)})
+		data = data[next:]
+	}
+	return lines, nil
+}
+
+// startLogCleanup removes expired execution logs now and then every hour.
+func startLogCleanup(db *gorm.DB) {
+	if logRetention <= 0 {
+		return
+	}
+	go func() {
+		for {
+			removeExpiredLogs(db, time.Now().Add(-logRetention))
+			time.Sleep(time.Hour)
+		}
+	}()
+}
+
+// removeExpiredLogs deletes the log files of executions that finished before
+// cutoff. Their head and tail stay in the database.
+func removeExpiredLogs(db *gorm.DB, cutoff time.Time) {
+	var executions []models.JobExecution
+	err := db.Unscoped().Select("id", "log_file").
+		Where("log_file <> '' AND status <> ? AND finished_at < ?", models.ExecutionStatusRunning, cutoff).
+		// The logs of other instances are on their disks.
+		Where("instance = ? OR instance = ''", instanceID).
+		Find(&executions).Error
+	if err != nil {
+		logger.L.Error("Failed to find expired execution logs", "error", err)
+		return
+	}
+
+	for _, execution := range executions {
+		os.Remove(logIndexPath(execution.LogFile))
+		if err := os.Remove(execution.LogFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.L.Error("Failed to remove execution log", "execution_id", execution.ID, "path", execution.LogFile, "error", err)
+			continue
+		}
+		db.Unscoped().Model(&models.JobExecution{}).Where("id = ?", execution.ID).Update("log_file", "")
+	}
+	if len(executions) > 0 {
+		logger.L.Info("Removed expired execution logs", "count", len(executions), "cutoff", cutoff)
+	}
+}
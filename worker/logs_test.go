@@ -0,0 +1,67 @@
+package worker
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestReadLog(t *testing.T) {
+	logDir = t.TempDir()
+	log, err := createLogFile(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output bytes.Buffer
+	for i := 0; output.Len() < 3*logMemberBytes; i++ {
+		line := fmt.Sprintf("line %d\n", i)
+		output.WriteString(line)
+		log.Write([]byte(line))
+	}
+	want := output.Bytes()
+
+	t.Run("flushes written output without further writes", func(t *testing.T) {
+		if log.flushTimer == nil {
+			t.Fatal("no flush is pending after a write")
+		}
+		log.timedFlush()
+		chunk, err := ReadLog(log.path, 0, 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if chunk.Size != int64(len(want)) || !bytes.Equal(chunk.Data, want[len(want)-10:]) {
+			t.Errorf("running log has size %d and tail %q, want %d and %q", chunk.Size, chunk.Data, len(want), want[len(want)-10:])
+		}
+	})
+
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if members := logMembers(log.path); len(members) < 3 {
+		t.Fatalf("log has %d members, want at least 3", len(members))
+	}
+
+	tests := []struct {
+		name                string
+		offset, limit, tail int64
+		from, to            int
+	}{
+		{name: "from the start", offset: 0, limit: 100, from: 0, to: 100},
+		{name: "across members", offset: logMemberBytes - 50, limit: 100, from: logMemberBytes - 50, to: logMemberBytes + 50},
+		{name: "in a later member", offset: 2*logMemberBytes + 7, limit: 20, from: 2*logMemberBytes + 7, to: 2*logMemberBytes + 27},
+		{name: "limited by the end", offset: int64(len(want)) - 5, limit: 100, from: len(want) - 5, to: len(want)},
+		{name: "past the end", offset: int64(len(want)) + 5, limit: 100, from: len(want), to: len(want)},
+		{name: "tail", tail: logMemberBytes + 3, from: len(want) - logMemberBytes - 3, to: len(want)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunk, err := ReadLog(log.path, tt.offset, tt.limit, tt.tail)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if chunk.Size != int64(len(want)) || chunk.Offset != int64(tt.from) || !bytes.Equal(chunk.Data, want[tt.from:tt.to]) {
+				t.Errorf("got %d bytes at %d of %d, want bytes %d to %d of %d", len(chunk.Data), chunk.Offset, chunk.Size, tt.from, tt.to, len(want))
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package worker
+
+import (
+	"errors"
+	"jobScheduler/logger"
+	"jobScheduler/models"
+	"jobScheduler/scheduler"
+	"time"
+)
+
+// misfireThreshold is how late a fire time may be handled and still count
+// as on time. Later ones are misfires and go through the job's policy.
+const misfireThreshold = time.Minute
+
+// queueFullRetryDelay is how soon the scheduler tries again to hand a job
+// to the workers after finding the queue full.
+const queueFullRetryDelay = time.Second
+
+// fire deals with every fire time of the job between its LastScheduledAt and
+// now. The most recent one is queued if it is on time; all others are
+// misfires and are dropped or queued according to the job's MisfirePolicy.
+//
+// When the queue is full the job's LastScheduledAt is only advanced past the
+// fire times already handled and the job is retried shortly, so the
+// remaining ones are judged again, as misfires once they are late enough.
+func (s *jobScheduler) fire(job models.Job, now time.Time) {
+	from := now.Add(-misfireThreshold)
+	if job.LastScheduledAt != nil {
+		from = *job.LastScheduledAt
+	}
+
+	limit := job.MisfireLimit
+	if limit <= 0 {
+		limit = models.DefaultMisfireLimit
+	}
+	// Only the newest runs matter: one on-time run plus at most limit misfires.
+	runs, older := scheduler.RunsBetween(job, from, now, limit+1)
+
+	grace := misfireThreshold + scheduler.JitterWindow(job.Schedule)
+	missed := runs
+	if len(runs) > 0 && now.Sub(runs[len(runs)-1]) <= grace {
+		missed = runs[:len(runs)-1]
+	}
+
+	// Decide, oldest first, which fire times get a run.
+	queued := make([]bool, len(runs))
+	switch job.MisfirePolicy {
+	case models.MisfirePolicyRunOnce:
+		if len(missed) > 0 {
+			queued[len(missed)-1] = true
+		}
+	case models.MisfirePolicyRunAll:
+		for i := max(0, len(missed)-limit); i < len(missed); i++ {
+			queued[i] = true
+		}
+	}
+	for i := len(missed); i < len(runs); i++ {
+		queued[i] = true
+	}
+
+	if len(missed)+older > 0 {
+		logger.L.Warn("Job missed scheduled runs", "job_id", job.ID, "missed", len(missed)+older, "policy", job.MisfirePolicy)
+	}
+
+	for i, runAt := range runs {
+		if !queued[i] {
+			continue
+		}
+		execution, err := enqueue(s.db, Run{Job: job, Attempt: 1, Trigger: models.TriggerSchedule}, now)
+		if err != nil {
+			if errors.Is(err, ErrQueueFull) {
+				logger.L.Warn("Job queue is full. Will retry.", "job_id", job.ID, "scheduled_at", runAt)
+			} else {
+				logger.L.Error("Failed to queue job. Will retry.", "job_id", job.ID, "scheduled_at", runAt, "error", err)
+			}
+			if i > 0 {
+				if err := s.db.Model(&job).Update("last_scheduled_at", runs[i-1]).Error; err != nil {
+					logger.L.Error("Failed to save job schedule progress", "job_id", job.ID, "error", err)
+				}
+			}
+			s.set(job.ID, now.Add(queueFullRetryDelay))
+			return
+		}
+		if execution.Status == models.ExecutionStatusQueued {
+			logger.L.Info("Job queued for execution", "job_id", job.ID, "execution_id", execution.ID, "scheduled_at", runAt)
+		}
+	}
+
+	// The next occurrence is strictly after now, so each fire time is
+	// handled exactly once.
+	if err := ArmJob(s.db, job, now); err != nil {
+		logger.L.Error("Failed to arm job", "job_id", job.ID, "error", err)
+	}
+}
@@ -0,0 +1,125 @@
+package worker
+
+import (
+	"fmt"
+	"io"
+	"jobScheduler/logger"
+	"sync"
+)
+
+// outputLimit is how many bytes of each stream of an execution are stored in
+// the database. Longer output keeps its first and last half of that; the
+// rest is only in the execution's log file.
+var outputLimit = 64 << 10
+
+// boundedBuffer keeps the head and tail of what is written to it.
+type boundedBuffer struct {
+	limit int
+	head  []byte
+	tail  []byte
+	total int64
+}
+
+func (b *boundedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	b.total += int64(n)
+	if room := b.limit/2 - len(b.head); room > 0 {
+		take := min(room, len(p))
+		b.head = append(b.head, p[:take]...)
+		p = p[take:]
+	}
+	b.tail = append(b.tail, p...)
+	// Trim only now and then, so that small writes stay cheap.
+	if keep := b.keep(); len(b.tail) > 2*keep {
+		b.tail = append(make([]byte, 0, 2*keep), b.tail[len(b.tail)-keep:]...)
+	}
+	return n, nil
+}
+
+func (b *boundedBuffer) keep() int {
+	return b.limit - b.limit/2
+}
+
+// Truncated reports whether anything was dropped between head and tail.
+func (b *boundedBuffer) Truncated() bool {
+	return b.total > int64(b.limit)
+}
+
+func (b *boundedBuffer) String() string {
+	tail := b.tail
+	if keep := b.keep(); len(tail) > keep {
+		tail = tail[len(tail)-keep:]
+	}
+	if !b.Truncated() {
+		return string(b.head) + string(tail)
+	}
+	omitted := b.total - int64(len(b.head)+len(tail))
+	return fmt.Sprintf("%s\n... %d bytes omitted, see the execution log ...\n%s", b.head, omitted, tail)
+}
+
+// executionOutput collects the output of an execution: the head and tail of
+// stdout, stderr and both interleaved for the database, each line for live
+// subscribers, and every line in the execution's log file.
+type executionOutput struct {
+	mu        sync.Mutex
+	combined  boundedBuffer
+	stdout    boundedBuffer
+	stderr    boundedBuffer
+	log       *logFile // nil when the execution has no log file
+	broadcast *logBroadcast
+}
+
+func newExecutionOutput(log *logFile, broadcast *logBroadcast) *executionOutput {
+	return &executionOutput{
+		combined:  boundedBuffer{limit: outputLimit},
+		stdout:    boundedBuffer{limit: outputLimit},
+		stderr:    boundedBuffer{limit: outputLimit},
+		log:       log,
+		broadcast: broadcast,
+	}
+}
+
+// Stdout and Stderr return the writers for the two streams. They may be
+// used from different goroutines.
+func (o *executionOutput) Stdout() io.Writer { return outputStream{o, &o.stdout, StreamStdout} }
+func (o *executionOutput) Stderr() io.Writer { return outputStream{o, &o.stderr, StreamStderr} }
+
+// Close publishes the unfinished lines and finishes the log file.
+func (o *executionOutput) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.writeLog(o.broadcast.flush())
+	if o.log == nil {
+		return nil
+	}
+	return o.log.Close()
+}
+
+type outputStream struct {
+	output *executionOutput
+	own    *boundedBuffer
+	name   string
+}
+
+func (s outputStream) Write(p []byte) (int, error) {
+	o := s.output
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	s.own.Write(p)
+	o.combined.Write(p)
+	o.writeLog(o.broadcast.write(s.name, p))
+	return len(p), nil
+}
+
+// writeLog must be called with o.mu held.
+func (o *executionOutput) writeLog(lines []byte) {
+	if o.log == nil || len(lines) == 0 {
+		return
+	}
+	if _, err := o.log.Write(lines); err != nil {
+		// Keep the head and tail at least; the log is incomplete anyway.
+		logger.L.Error("Failed to write execution log", "path", o.log.path, "error", err)
+		o.log.Close()
+		o.log = nil
+	}
+}
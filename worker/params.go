@@ -0,0 +1,75 @@
+package worker
+
+import (
+	"fmt"
+	"jobScheduler/models"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// isTemplated reports whether a command uses template actions. Commands
+// without them run exactly as written.
+func isTemplated(command string) bool {
+	return strings.Contains(command, "{{")
+}
+
+func parseCommandTemplate(command string) (*template.Template, error) {
+	tmpl, err := template.New("command").Option("missingkey=error").Parse(command)
+	if err != nil {
+		return nil, fmt.Errorf("invalid command template: %v", err)
+	}
+	return tmpl, nil
+}
+
+// RenderCommand fills in a templated command for a run started at now. The
+// template sees:
+//
+//	.date      2006-01-02, in the job's time zone
+//	.time      15:04:05
+//	.datetime  RFC 3339
+//	.timestamp Unix seconds
+//	.job       the job's name, quoted for the shell
+//	.params    the parameters of the run, each quoted for the shell
+//
+// A parameter the command uses but the run does not have is an error; use
+// {{or (index .params "name") "default"}} for optional ones. Parameters come
+// from whoever runs the job, and anyone may rename it, so both are quoted:
+// {{.params.name}} and {{.job}} are always one word to the shell, whatever
+// they contain.
+func RenderCommand(job models.Job, params map[string]string, now time.Time) (string, error) {
+	if !isTemplated(job.Command) {
+		return job.Command, nil
+	}
+	tmpl, err := parseCommandTemplate(job.Command)
+	if err != nil {
+		return "", err
+	}
+
+	if loc, err := job.Schedule.Location(); err == nil {
+		now = now.In(loc)
+	}
+	quoted := make(map[string]string, len(params))
+	for name, value := range params {
+		quoted[name] = shellQuote(value)
+	}
+	data := map[string]any{
+		"date":      now.Format(time.DateOnly),
+		"time":      now.Format(time.TimeOnly),
+		"datetime":  now.Format(time.RFC3339),
+		"timestamp": now.Unix(),
+		"job":       shellQuote(job.Name),
+		"params":    quoted,
+	}
+
+	var command strings.Builder
+	if err := tmpl.Execute(&command, data); err != nil {
+		return "", fmt.Errorf("cannot render command: %v", err)
+	}
+	return command.String(), nil
+}
+
+// shellQuote quotes value as a single word for sh and bash.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
@@ -0,0 +1,59 @@
+package worker
+
+import (
+	"jobScheduler/models"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// TestRenderCommandQuotes checks that the job name and params reach the
+// shell as one word each, whatever they contain.
+func TestRenderCommandQuotes(t *testing.T) {
+	tests := []struct {
+		name    string
+		job     models.Job
+		params  map[string]string
+		want    string
+		printed string
+	}{
+		{
+			name:    "job name with shell metacharacters",
+			job:     models.Job{Name: "x; echo pwned $API_TOKEN", Command: "printf %s {{.job}}"},
+			want:    `printf %s 'x; echo pwned $API_TOKEN'`,
+			printed: "x; echo pwned $API_TOKEN",
+		},
+		{
+			name:    "job name with a quote",
+			job:     models.Job{Name: "it's $(id)", Command: "printf %s {{.job}}"},
+			want:    `printf %s 'it'\''s $(id)'`,
+			printed: "it's $(id)",
+		},
+		{
+			name:    "param with shell metacharacters",
+			job:     models.Job{Name: "export", Command: "printf %s {{.params.region}}"},
+			params:  map[string]string{"region": "eu`reboot`|x"},
+			want:    "printf %s 'eu`reboot`|x'",
+			printed: "eu`reboot`|x",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RenderCommand(tt.job, tt.params, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("RenderCommand = %q, want %q", got, tt.want)
+			}
+			out, err := exec.Command("sh", "-c", got).Output()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != tt.printed {
+				t.Errorf("the shell printed %q, want %q", out, tt.printed)
+			}
+		})
+	}
+}
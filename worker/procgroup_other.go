@@ -0,0 +1,25 @@
+//go:build !unix
+
+package worker
+
+import (
+	"os"
+	"os/exec"
+)
+
+// Process groups are a Unix concept; elsewhere only the direct child is
+// stopped.
+
+func startInOwnGroup(cmd *exec.Cmd) {}
+
+func terminateGroup(p *os.Process) error {
+	return p.Kill()
+}
+
+func killGroup(p *os.Process) error {
+	return p.Kill()
+}
+
+func exitSignal(state *os.ProcessState) string {
+	return ""
+}
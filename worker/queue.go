@@ -0,0 +1,474 @@
+package worker
+
+import (
+	"fmt"
+	"jobScheduler/logger"
+	"jobScheduler/models"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Workers hold a queue item for leaseDuration and renew the lease every
+// leaseRenewInterval while the run lasts. An item whose lease has expired
+// belonged to a worker that went away and is reclaimed.
+const (
+	leaseDuration      = 30 * time.Second
+	leaseRenewInterval = 10 * time.Second
+)
+
+// queuePollInterval is how often idle workers look for items they were not
+// woken up for, such as retries whose delay has passed.
+const queuePollInterval = time.Second
+
+// maxDeliveries is how often an item is leased again after its worker went
+// away before its execution started. After that it is abandoned.
+const maxDeliveries = 3
+
+// doneRetention is how long finished queue items are kept.
+const doneRetention = 24 * time.Hour
+
+// workerQueue is a named queue with workers of its own.
+type workerQueue struct {
+	name     string
+	workers  int
+	capacity int // how many runs may wait; retries are queued even when full
+}
+
+// queues holds every configured queue by name.
+var queues map[string]*workerQueue
+
+// queueChanged is closed, and replaced, whenever this process queues a run,
+// to wake the workers and agents waiting for one.
+var (
+	queueChangedMu sync.Mutex
+	queueChanged   = make(chan struct{})
+)
+
+// QueueNames returns the names of the configured queues.
+func QueueNames() []string {
+	names := make([]string, 0, len(queues))
+	for name := range queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// QueueStat describes a queue and how busy it is.
+type QueueStat struct {
+	Name     string `json:"name"`
+	Workers  int    `json:"workers"`
+	Capacity int    `json:"capacity"`
+	Waiting  int64  `json:"waiting"` // enqueued runs, including retries waiting out their delay
+	Leased   int64  `json:"leased"`  // runs held by a worker
+	// Labels are the labels of the queue's workers in this process.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// QueueStats returns every configured queue, the default one first.
+func QueueStats(db *gorm.DB) ([]QueueStat, error) {
+	var counts []struct {
+		Queue string
+		State string
+		Count int64
+	}
+	err := db.Model(&models.QueueItem{}).
+		Select("queue, state, count(*) AS count").
+		Where("state <> ?", models.QueueStateDone).
+		Group("queue, state").
+		Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	names := QueueNames()
+	sort.SliceStable(names, func(i, j int) bool { return names[i] == models.DefaultQueue && names[j] != models.DefaultQueue })
+	stats := make([]QueueStat, 0, len(names))
+	for _, name := range names {
+		q := queues[name]
+		stat := QueueStat{Name: name, Workers: q.workers, Capacity: q.capacity}
+		if q.workers > 0 {
+			stat.Labels = workerLabels
+		}
+		for _, c := range counts {
+			switch {
+			case c.Queue != name:
+			case c.State == models.QueueStateEnqueued:
+				stat.Waiting = c.Count
+			case c.State == models.QueueStateLeased:
+				stat.Leased = c.Count
+			}
+		}
+		stats = append(stats, stat)
+	}
+	return stats, nil
+}
+
+// ValidateQueue checks that a job's queue is configured. An empty name
+// stands for the default queue.
+func ValidateQueue(name string) error {
+	if name == "" {
+		return nil
+	}
+	if _, ok := queues[name]; !ok {
+		return fmt.Errorf("unknown queue %q: must be one of %s", name, strings.Join(QueueNames(), ", "))
+	}
+	return nil
+}
+
+// queueFor returns the queue that runs the job. A job whose queue is no
+// longer configured runs in the default queue.
+func queueFor(job models.Job) *workerQueue {
+	if q, ok := queues[job.Queue]; ok {
+		return q
+	}
+	return queues[models.DefaultQueue]
+}
+
+// instanceID identifies this process in the leases it holds.
+var instanceID = fmt.Sprintf("%s:%d", hostname, os.Getpid())
+
+// enqueue records run as a queued execution together with the queue item
+// that hands it to a worker of the job's queue once availableAt has passed.
+// Unless the run is a retry it is refused with ErrQueueFull when the queue
+// has no room. A run that no worker or agent can take because of the
+// job's node selector is not queued but recorded as unschedulable.
+func enqueue(db *gorm.DB, run Run, availableAt time.Time) (models.JobExecution, error) {
+	if run.Attempt < 1 {
+		run.Attempt = 1
+	}
+	q := queueFor(run.Job)
+	execution := newExecution(run, models.ExecutionStatusQueued, "")
+	execution.StartedAt = time.Time{}
+	execution.Hostname = ""
+	execution.Instance = ""
+
+	eligible, err := hasEligibleWorker(db, q.name, run.Job.NodeSelector)
+	if err != nil {
+		return execution, err
+	}
+	if !eligible {
+		return recordUnschedulable(db, run, execution, unschedulableReason(q.name, run.Job.NodeSelector))
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if run.Trigger != models.TriggerRetry {
+			var waiting int64
+			if err := tx.Model(&models.QueueItem{}).Where("state = ? AND queue = ?", models.QueueStateEnqueued, q.name).Count(&waiting).Error; err != nil {
+				return err
+			}
+			if waiting >= int64(q.capacity) {
+				return ErrQueueFull
+			}
+		}
+		if err := tx.Create(&execution).Error; err != nil {
+			return err
+		}
+		if execution.RunID == 0 {
+			execution.RunID = execution.ID
+			if err := tx.Model(&execution).Update("run_id", execution.RunID).Error; err != nil {
+				return err
+			}
+		}
+		// Workers cannot see the item before the transaction commits, so
+		// the execution cannot finish before it is waited for.
+		finishedMu.Lock()
+		finished[execution.ID] = make(chan struct{})
+		finishedMu.Unlock()
+
+		return tx.Create(&models.QueueItem{
+			JobID:        run.Job.ID,
+			ExecutionID:  execution.ID,
+			Queue:        q.name,
+			Priority:     run.Job.Priority,
+			RunID:        execution.RunID,
+			Attempt:      execution.Attempt,
+			Trigger:      execution.Trigger,
+			Params:       run.Params,
+			NodeSelector: run.Job.NodeSelector,
+			State:        models.QueueStateEnqueued,
+			AvailableAt:  availableAt,
+		}).Error
+	})
+	if err != nil {
+		if execution.ID != 0 {
+			finishedMu.Lock()
+			delete(finished, execution.ID)
+			finishedMu.Unlock()
+		}
+		return execution, err
+	}
+
+	wakeWorkers()
+	return execution, nil
+}
+
+func wakeWorkers() {
+	queueChangedMu.Lock()
+	close(queueChanged)
+	queueChanged = make(chan struct{})
+	queueChangedMu.Unlock()
+}
+
+// queueWait returns a channel that is closed once a run is queued.
+func queueWait() <-chan struct{} {
+	queueChangedMu.Lock()
+	defer queueChangedMu.Unlock()
+	return queueChanged
+}
+
+// waitForWork blocks until a run is queued or queuePollInterval has passed.
+func waitForWork() {
+	timer := time.NewTimer(queuePollInterval)
+	defer timer.Stop()
+	select {
+	case <-queueWait():
+	case <-timer.C:
+	}
+}
+
+// lease takes the available item of the given queues with the highest
+// priority, the oldest one among equals, whose node selector matches labels,
+// for holder. It returns false when there is none.
+func lease(db *gorm.DB, queueNames []string, holder string, labels map[string]string) (models.QueueItem, bool) {
+	now := time.Now()
+	for offset := 0; ; offset += leaseScanBatch {
+		var items []models.QueueItem
+		err := db.Where("state = ? AND queue IN ? AND available_at <= ?", models.QueueStateEnqueued, queueNames, now).
+			Order("priority DESC, available_at, id").
+			Offset(offset).
+			Limit(leaseScanBatch).
+			Find(&items).Error
+		if err != nil {
+			logger.L.Error("Failed to read the job queue", "error", err)
+			return models.QueueItem{}, false
+		}
+
+		for _, item := range items {
+			if !matchesSelector(labels, item.NodeSelector) {
+				continue
+			}
+			expires := now.Add(leaseDuration)
+			result := db.Model(&models.QueueItem{}).
+				Where("id = ? AND state = ?", item.ID, models.QueueStateEnqueued).
+				Updates(map[string]interface{}{
+					"state":            models.QueueStateLeased,
+					"leased_by":        holder,
+					"lease_expires_at": expires,
+					"deliveries":       gorm.Expr("deliveries + 1"),
+				})
+			if result.Error != nil {
+				logger.L.Error("Failed to lease a queue item", "item_id", item.ID, "error", result.Error)
+				return item, false
+			}
+			if result.RowsAffected == 1 {
+				item.State = models.QueueStateLeased
+				item.LeasedBy = holder
+				item.LeaseExpiresAt = &expires
+				item.Deliveries++
+				return item, true
+			}
+			// Another worker took it first.
+		}
+		if len(items) < leaseScanBatch {
+			return models.QueueItem{}, false
+		}
+	}
+}
+
+// keepLeased renews the lease of item until the returned function is called.
+func keepLeased(db *gorm.DB, item models.QueueItem) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(leaseRenewInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				result := db.Model(&models.QueueItem{}).
+					Where("id = ? AND state = ? AND leased_by = ?", item.ID, models.QueueStateLeased, item.LeasedBy).
+					Update("lease_expires_at", time.Now().Add(leaseDuration))
+				if result.Error != nil {
+					logger.L.Error("Failed to renew lease", "item_id", item.ID, "error", result.Error)
+					continue
+				}
+				if result.RowsAffected == 0 {
+					logger.L.Warn("Lost the lease of a running queue item", "item_id", item.ID, "execution_id", item.ExecutionID)
+					return
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// completeItem marks a leased item as done.
+func completeItem(db *gorm.DB, item models.QueueItem) {
+	err := db.Model(&models.QueueItem{}).
+		Where("id = ? AND leased_by = ?", item.ID, item.LeasedBy).
+		Updates(map[string]interface{}{
+			"state":            models.QueueStateDone,
+			"lease_expires_at": nil,
+			"finished_at":      time.Now(),
+		}).Error
+	if err != nil {
+		logger.L.Error("Failed to complete queue item", "item_id", item.ID, "error", err)
+	}
+}
+
+// runFor loads the job of a leased item. It returns false if the job has
+// been deleted since the item was queued, after recording that on the
+// execution.
+func runFor(db *gorm.DB, item models.QueueItem) (Run, bool) {
+	run := Run{
+		RunID:       item.RunID,
+		Attempt:     item.Attempt,
+		Trigger:     item.Trigger,
+		Params:      item.Params,
+		ExecutionID: item.ExecutionID,
+	}
+	if err := db.First(&run.Job, item.JobID).Error; err != nil {
+		logger.L.Warn("Dropping queued run of a job that no longer exists", "job_id", item.JobID, "execution_id", item.ExecutionID, "error", err)
+		db.Model(&models.JobExecution{}).
+			Where("id = ? AND status = ?", item.ExecutionID, models.ExecutionStatusQueued).
+			Updates(map[string]interface{}{
+				"status":      models.ExecutionStatusCancelled,
+				"output":      "the job was deleted before the run started",
+				"finished_at": time.Now(),
+			})
+		notifyFinished(item.ExecutionID)
+		return run, false
+	}
+	return run, true
+}
+
+// reclaimExpired deals with the items whose worker went away. An item whose
+// execution had not started yet is queued again, up to maxDeliveries times.
+// Otherwise the execution is marked abandoned: its command may have partly
+// run, so it is not run again.
+func reclaimExpired(db *gorm.DB, now time.Time) {
+	var items []models.QueueItem
+	if err := db.Where("state = ? AND lease_expires_at < ?", models.QueueStateLeased, now).Find(&items).Error; err != nil {
+		logger.L.Error("Failed to read expired leases", "error", err)
+		return
+	}
+
+	for _, item := range items {
+		var execution models.JobExecution
+		started := db.First(&execution, item.ExecutionID).Error != nil || execution.Status != models.ExecutionStatusQueued
+		expired := db.Model(&models.QueueItem{}).Where("id = ? AND state = ? AND lease_expires_at < ?", item.ID, models.QueueStateLeased, now)
+
+		if !started && item.Deliveries < maxDeliveries {
+			result := expired.Updates(map[string]interface{}{
+				"state":            models.QueueStateEnqueued,
+				"leased_by":        "",
+				"lease_expires_at": nil,
+			})
+			if result.Error == nil && result.RowsAffected == 1 {
+				logger.L.Warn("Queued run again after its worker went away", "item_id", item.ID, "execution_id", item.ExecutionID, "leased_by", item.LeasedBy, "deliveries", item.Deliveries)
+				wakeWorkers()
+			}
+			continue
+		}
+
+		result := expired.Updates(map[string]interface{}{
+			"state":            models.QueueStateDone,
+			"lease_expires_at": nil,
+			"finished_at":      now,
+		})
+		if result.Error != nil || result.RowsAffected == 0 {
+			// Renewed in the meantime.
+			continue
+		}
+		reason := fmt.Sprintf("worker %s went away", item.LeasedBy)
+		if !abandonAgentRun(db, item.ExecutionID, reason) {
+			abandonExecution(db, item.ExecutionID, reason)
+		}
+	}
+}
+
+// abandonExecution marks an execution that will never finish as abandoned,
+// unless it has finished after all.
+func abandonExecution(db *gorm.DB, executionID uint, reason string) {
+	var execution models.JobExecution
+	if err := db.First(&execution, executionID).Error; err != nil {
+		return
+	}
+	if execution.Status != models.ExecutionStatusQueued && execution.Status != models.ExecutionStatusRunning {
+		return
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{
+		"status":      models.ExecutionStatusAbandoned,
+		"finished_at": now,
+	}
+	if execution.Output == "" {
+		updates["output"] = reason
+	}
+	if !execution.StartedAt.IsZero() {
+		updates["duration_ms"] = now.Sub(execution.StartedAt).Milliseconds()
+	}
+	result := db.Model(&execution).Where("status = ?", execution.Status).Updates(updates)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return
+	}
+	db.Model(&models.Job{}).Where("id = ?", execution.JobID).Update("last_status", models.ExecutionStatusAbandoned)
+	notifyFinished(executionID)
+	logger.L.Warn("Abandoned execution", "job_id", execution.JobID, "execution_id", executionID, "reason", reason)
+}
+
+// abandonStale runs at startup, before any worker of this process has
+// started. Executions still recorded as running that are not held by a live
+// lease, and queued ones without a queue item, were left behind by an
+// earlier process and are marked abandoned.
+func abandonStale(db *gorm.DB, now time.Time) {
+	held := db.Model(&models.QueueItem{}).Select("execution_id").
+		Where("state = ? AND lease_expires_at >= ?", models.QueueStateLeased, now)
+	pending := db.Model(&models.QueueItem{}).Select("execution_id").
+		Where("state <> ?", models.QueueStateDone)
+
+	var stale []models.JobExecution
+	err := db.Where("status = ? AND id NOT IN (?)", models.ExecutionStatusRunning, held).
+		Or("status = ? AND id NOT IN (?)", models.ExecutionStatusQueued, pending).
+		Find(&stale).Error
+	if err != nil {
+		logger.L.Error("Failed to look for stale executions", "error", err)
+		return
+	}
+	for _, execution := range stale {
+		abandonExecution(db, execution.ID, "the server stopped while the execution was "+execution.Status)
+	}
+	if len(stale) > 0 {
+		logger.L.Warn("Reclaimed stale executions", "count", len(stale))
+	}
+}
+
+// startQueueReaper reclaims expired leases and removes old finished items
+// for as long as the process runs.
+func startQueueReaper(db *gorm.DB) {
+	go func() {
+		ticker := time.NewTicker(leaseRenewInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			reclaimExpired(db, now)
+			reclaimUnschedulable(db, now)
+			pruneAgentRuns(db)
+			pruneFinished(db)
+			removeOldAgents(db, now)
+			removeOldInstances(db, now)
+			err := db.Where("state = ? AND finished_at < ?", models.QueueStateDone, now.Add(-doneRetention)).
+				Delete(&models.QueueItem{}).Error
+			if err != nil {
+				logger.L.Error("Failed to remove finished queue items", "error", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,39 @@
+package worker
+
+import (
+	"errors"
+	"jobScheduler/logger"
+	"jobScheduler/models"
+	"os/exec"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// retryable reports whether an attempt that ended with status may be tried
+// again. Cancelled runs never are.
+func retryable(status string, err error) bool {
+	return err != nil && (status == models.ExecutionStatusFailed || status == models.ExecutionStatusTimedOut)
+}
+
+// exitCode returns the exit code of a failed command, or -1 if it did not
+// exit normally.
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// retryLater queues the next attempt of a run to start once delay has
+// passed. The attempt is stored in the queue right away, so it survives a
+// restart, and it runs the job as it is by then.
+func retryLater(db *gorm.DB, run Run, delay time.Duration) {
+	execution, err := enqueue(db, run, time.Now().Add(delay))
+	if err != nil {
+		logger.L.Error("Failed to queue retry", "job_id", run.Job.ID, "run_id", run.RunID, "attempt", run.Attempt, "error", err)
+		return
+	}
+	logger.L.Info("Job queued for retry", "job_id", run.Job.ID, "run_id", run.RunID, "attempt", run.Attempt, "execution_id", execution.ID, "delay", delay)
+}
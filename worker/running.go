@@ -0,0 +1,95 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"jobScheduler/logger"
+	"sync"
+)
+
+// ErrExecutionNotRunning is returned by CancelExecution for executions that
+// are not in flight in this process.
+var ErrExecutionNotRunning = errors.New("execution is not running")
+
+// errCancelled is the cancellation cause of executions stopped through
+// CancelExecution or replaced under the replace concurrency policy.
+var errCancelled = errors.New("execution cancelled")
+
+// runningExecution is an in-flight execution that can be cancelled.
+type runningExecution struct {
+	mu          sync.Mutex
+	jobID       uint
+	cancel      context.CancelCauseFunc
+	pid         int
+	cancelledBy string
+}
+
+func (r *runningExecution) setPID(pid int) {
+	r.mu.Lock()
+	r.pid = pid
+	r.mu.Unlock()
+}
+
+// CancelledBy returns who cancelled the execution, or "" if nobody did.
+func (r *runningExecution) CancelledBy() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cancelledBy
+}
+
+// stop cancels the execution on behalf of by and returns its process ID.
+func (r *runningExecution) stop(by string) int {
+	r.mu.Lock()
+	if r.cancelledBy == "" {
+		r.cancelledBy = by
+	}
+	pid := r.pid
+	r.mu.Unlock()
+
+	r.cancel(errCancelled)
+	return pid
+}
+
+var (
+	runningMu sync.Mutex
+	running   = make(map[uint]*runningExecution)
+	// activeRuns counts the runs of each job admitted by admit and not yet
+	// released, including those whose execution is not tracked yet.
+	activeRuns = make(map[uint]int)
+	noTracking = &runningExecution{cancel: func(error) {}}
+)
+
+// track registers an execution so that CancelExecution can stop it.
+// Executions without an ID could not be saved and are not tracked.
+func track(executionID, jobID uint, cancel context.CancelCauseFunc) *runningExecution {
+	if executionID == 0 {
+		return noTracking
+	}
+	r := &runningExecution{jobID: jobID, cancel: cancel}
+	runningMu.Lock()
+	running[executionID] = r
+	runningMu.Unlock()
+	return r
+}
+
+func untrack(executionID uint) {
+	runningMu.Lock()
+	delete(running, executionID)
+	runningMu.Unlock()
+}
+
+// CancelExecution stops an in-flight execution by terminating its process
+// group, as a timeout would. The worker running it records the execution
+// as cancelled by username once the process has exited.
+func CancelExecution(executionID uint, username string) error {
+	runningMu.Lock()
+	r, ok := running[executionID]
+	runningMu.Unlock()
+	if !ok {
+		return ErrExecutionNotRunning
+	}
+
+	pid := r.stop(username)
+	logger.L.Info("Cancelling execution", "execution_id", executionID, "pid", pid, "user", username)
+	return nil
+}
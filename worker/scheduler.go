@@ -0,0 +1,313 @@
+package worker
+
+import (
+	"container/heap"
+	"jobScheduler/logger"
+	"jobScheduler/models"
+	"jobScheduler/scheduler"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// scheduledJob is a heap entry holding the next fire time of one job.
+type scheduledJob struct {
+	jobID uint
+	runAt time.Time
+	index int
+}
+
+// jobHeap is a min-heap of scheduled jobs ordered by fire time.
+type jobHeap []*scheduledJob
+
+func (h jobHeap) Len() int           { return len(h) }
+func (h jobHeap) Less(i, j int) bool { return h[i].runAt.Before(h[j].runAt) }
+func (h jobHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *jobHeap) Push(x any) {
+	entry := x.(*scheduledJob)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+func (h *jobHeap) Pop() any {
+	old := *h
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return entry
+}
+
+// jobScheduler keeps every enabled job in a heap keyed by its next fire
+// time and sleeps until the earliest one is due, instead of polling the
+// jobs table. Only the instance holding the scheduler leader lease runs one.
+type jobScheduler struct {
+	mu      sync.Mutex
+	heap    jobHeap
+	entries map[uint]*scheduledJob
+	wake    chan struct{}
+	stopped chan struct{}
+	db      *gorm.DB
+}
+
+// activeScheduler is the running scheduler, or nil while this instance is
+// not the leader.
+var (
+	activeSchedulerMu sync.Mutex
+	activeScheduler   *jobScheduler
+)
+
+func currentScheduler() *jobScheduler {
+	activeSchedulerMu.Lock()
+	defer activeSchedulerMu.Unlock()
+	return activeScheduler
+}
+
+// startScheduler starts the scheduling loop. Fire times missed while no
+// instance was scheduling are handled first. It returns right away, so that
+// the lease keeps being renewed while that takes.
+func startScheduler(db *gorm.DB) {
+	s := &jobScheduler{
+		entries: make(map[uint]*scheduledJob),
+		wake:    make(chan struct{}, 1),
+		stopped: make(chan struct{}),
+		db:      db,
+	}
+	activeSchedulerMu.Lock()
+	activeScheduler = s
+	activeSchedulerMu.Unlock()
+
+	go func() {
+		s.recoverEnabledJobs(time.Now())
+		go s.followSavedJobs()
+		s.run()
+	}()
+}
+
+// stopScheduler stops the scheduling loop, if it runs.
+func stopScheduler() {
+	activeSchedulerMu.Lock()
+	s := activeScheduler
+	activeScheduler = nil
+	activeSchedulerMu.Unlock()
+	if s != nil {
+		close(s.stopped)
+	}
+}
+
+// idleWait is how long the loop sleeps when no job is scheduled at all.
+// Any change to the heap wakes it earlier.
+const idleWait = time.Hour
+
+func (s *jobScheduler) run() {
+	for {
+		wait := idleWait
+		s.mu.Lock()
+		if len(s.heap) > 0 {
+			wait = time.Until(s.heap[0].runAt)
+		}
+		s.mu.Unlock()
+
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-s.wake:
+				timer.Stop()
+				continue
+			case <-s.stopped:
+				timer.Stop()
+				return
+			}
+		}
+
+		s.fireDue(time.Now())
+	}
+}
+
+// fireDue hands every job whose fire time has been reached to fire.
+func (s *jobScheduler) fireDue(now time.Time) {
+	var due []*scheduledJob
+	s.mu.Lock()
+	for len(s.heap) > 0 && !s.heap[0].runAt.After(now) {
+		entry := heap.Pop(&s.heap).(*scheduledJob)
+		delete(s.entries, entry.jobID)
+		due = append(due, entry)
+	}
+	s.mu.Unlock()
+
+	for _, entry := range due {
+		if !isLeader() {
+			// Leadership was lost; the next leader deals with the rest.
+			return
+		}
+		var job models.Job
+		if err := s.db.First(&job, entry.jobID).Error; err != nil {
+			logger.L.Warn("Scheduled job no longer exists", "job_id", entry.jobID, "error", err)
+			continue
+		}
+		if job.Status != models.JobStatusEnabled {
+			continue
+		}
+		s.fire(job, now)
+	}
+}
+
+func (s *jobScheduler) set(jobID uint, runAt time.Time) {
+	s.mu.Lock()
+	if entry, ok := s.entries[jobID]; ok {
+		entry.runAt = runAt
+		heap.Fix(&s.heap, entry.index)
+	} else {
+		entry := &scheduledJob{jobID: jobID, runAt: runAt}
+		heap.Push(&s.heap, entry)
+		s.entries[jobID] = entry
+	}
+	s.mu.Unlock()
+	s.notify()
+}
+
+func (s *jobScheduler) remove(jobID uint) {
+	s.mu.Lock()
+	if entry, ok := s.entries[jobID]; ok {
+		heap.Remove(&s.heap, entry.index)
+		delete(s.entries, jobID)
+	}
+	s.mu.Unlock()
+	s.notify()
+}
+
+// notify wakes the loop so it recomputes how long to sleep.
+func (s *jobScheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// ScheduleJob brings the scheduler in line with a job that was just saved,
+// using the NextRunAt already stored on it.
+// On other instances the leader picks the change up from the database.
+func ScheduleJob(job models.Job) {
+	s := currentScheduler()
+	if s == nil {
+		return
+	}
+	if job.Status != models.JobStatusEnabled || job.NextRunAt == nil {
+		s.remove(job.ID)
+		return
+	}
+	s.set(job.ID, *job.NextRunAt)
+}
+
+// UnscheduleJob drops a deleted job from the scheduler.
+func UnscheduleJob(jobID uint) {
+	s := currentScheduler()
+	if s == nil {
+		return
+	}
+	s.remove(jobID)
+}
+
+// savedJobsInterval is how often the scheduler looks for jobs saved through
+// other instances.
+const savedJobsInterval = 2 * time.Second
+
+// followSavedJobs keeps the heap in line with jobs created, changed or
+// deleted through the API of other instances, which cannot reach this
+// scheduler directly, until the scheduler is stopped.
+func (s *jobScheduler) followSavedJobs() {
+	ticker := time.NewTicker(savedJobsInterval)
+	defer ticker.Stop()
+	// Overlap the windows a little, so that saves committed while a query
+	// runs are not missed.
+	since := time.Now().Add(-savedJobsInterval)
+	for {
+		select {
+		case <-s.stopped:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		var jobs []models.Job
+		if err := s.db.Unscoped().Where("updated_at > ? OR deleted_at > ?", since, since).Find(&jobs).Error; err != nil {
+			logger.L.Error("Failed to look for saved jobs", "error", err)
+			continue
+		}
+		since = now.Add(-savedJobsInterval)
+
+		for _, job := range jobs {
+			if job.DeletedAt.Valid {
+				s.remove(job.ID)
+				continue
+			}
+			if job.Status != models.JobStatusEnabled || job.NextRunAt == nil {
+				s.remove(job.ID)
+				continue
+			}
+			s.set(job.ID, *job.NextRunAt)
+		}
+	}
+}
+
+// recoverEnabledJobs runs every enabled job through fire once at startup,
+// so that fire times missed while the process was down are handled by the
+// job's misfire policy before it is armed again.
+func (s *jobScheduler) recoverEnabledJobs(now time.Time) {
+	var jobs []models.Job
+	if err := s.db.Where("status = ?", models.JobStatusEnabled).Find(&jobs).Error; err != nil {
+		logger.L.Error("Failed to load enabled jobs", "error", err)
+		return
+	}
+
+	for _, job := range jobs {
+		select {
+		case <-s.stopped:
+			return
+		default:
+		}
+		if !isLeader() {
+			return
+		}
+		s.fire(job, now)
+	}
+}
+
+// ArmJob stores the first time after t at which the job should fire and
+// updates the scheduler to match. Fire times up to t are considered dealt
+// with, so none of them will be reported as missed later. A job that is not
+// enabled has no next run, and an enabled job whose schedule is exhausted is
+// retired as completed.
+func ArmJob(db *gorm.DB, job models.Job, t time.Time) error {
+	if job.Status != models.JobStatusEnabled {
+		UnscheduleJob(job.ID)
+		return db.Model(&job).Update("next_run_at", nil).Error
+	}
+
+	next, ok := scheduler.NextRun(job, t)
+	if !ok {
+		logger.L.Info("Job schedule has no occurrences left", "job_id", job.ID)
+		UnscheduleJob(job.ID)
+		return db.Model(&job).Updates(map[string]interface{}{
+			"status":            models.JobStatusCompleted,
+			"next_run_at":       nil,
+			"last_scheduled_at": t,
+		}).Error
+	}
+
+	next = scheduler.WithJitter(job.Schedule, next)
+	err := db.Model(&job).Updates(map[string]interface{}{
+		"next_run_at":       next,
+		"last_scheduled_at": t,
+	}).Error
+	if err != nil {
+		return err
+	}
+	job.NextRunAt = &next
+	ScheduleJob(job)
+	return nil
+}
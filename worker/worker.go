@@ -1,117 +1,334 @@
 package worker
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"jobScheduler/config"
 	"jobScheduler/logger"
 	"jobScheduler/models"
-	"jobScheduler/scheduler"
+	"jobScheduler/secrets"
 	"os"
-	"os/exec"
-	"path/filepath"
-	"strings"
 	"time"
 
 	"gorm.io/gorm"
 )
 
-var JobQueue chan models.Job
+// Run is one attempt of a job, as taken from the queue.
+type Run struct {
+	Job     models.Job
+	RunID   uint   // ID of the first execution of the run; zero for a new run
+	Attempt int    // counts from 1
+	Trigger string // what started the run, one of the models.Trigger constants
+	// WorkerID is the pool worker executing the run, or zero when it is run
+	// directly.
+	WorkerID int
+	// Params are filled into the job's Command if it is a template; see
+	// RenderCommand.
+	Params map[string]string
+	// ExecutionID is the execution recorded for the run while it waits in
+	// the queue, if any.
+	ExecutionID uint
+	// Agent is the agent executing the run, or nil when it runs in this
+	// process.
+	Agent *models.Agent
+}
+
+// defaultJobTimeout applies to jobs without TimeoutSeconds. Zero means no limit.
+var defaultJobTimeout time.Duration
 
-func StartWorkerPool(poolSize int, queueSize int, db *gorm.DB) {
-	JobQueue = make(chan models.Job, queueSize)
-	logger.L.Info("Job queue initialized", "size", queueSize)
+// hostname is recorded on every execution run by this process.
+var hostname, _ = os.Hostname()
 
-	for i := 1; i <= poolSize; i++ {
-		go worker(i, db)
+// killGracePeriod is how long a timed out process group gets to exit after
+// SIGTERM before it is sent SIGKILL.
+const killGracePeriod = 5 * time.Second
+
+func StartWorkerPool(cfg *config.WorkerConfig, db *gorm.DB) {
+	defaultJobTimeout = cfg.JobTimeout
+	outputLimit = cfg.OutputLimit
+	logDir = cfg.LogDir
+	logRetention = cfg.LogRetention
+	setWorkerLabels(cfg.Labels)
+	if err := os.MkdirAll(logDir, 0700); err != nil {
+		logger.L.Error("Failed to create execution log directory. Only the head and tail of output will be kept.", "dir", logDir, "error", err)
 	}
-	logger.L.Info("Worker pool started", "workers", poolSize)
 
-	go schedulerTicker(db)
-	logger.L.Info("Scheduler started")
-}
+	queues = make(map[string]*workerQueue, len(cfg.Queues))
+	for _, qc := range cfg.Queues {
+		queues[qc.Name] = &workerQueue{
+			name:     qc.Name,
+			workers:  qc.Workers,
+			capacity: qc.Capacity,
+		}
+	}
+	abandonStale(db, time.Now())
+	startInstanceHeartbeat(db, cfg.InstanceURL)
 
-func worker(id int, db *gorm.DB) {
-	for job := range JobQueue {
-		logger.L.Info("Worker picked up a job", "worker_id", id, "job_id", job.ID)
+	for _, q := range queues {
+		for i := 1; i <= q.workers; i++ {
+			go q.worker(i, db)
+		}
+		logger.L.Info("Worker pool started", "queue", q.name, "workers", q.workers, "capacity", q.capacity, "labels", workerLabels)
+	}
+	logger.L.Info("Job queues initialized", "queues", len(queues), "default_timeout", cfg.JobTimeout)
 
-		// Update main job status to "running"
-		db.Model(&job).Updates(map[string]interface{}{"status": "running", "last_run_at": time.Now()})
+	startLeaderElection(db)
+	logger.L.Info("Campaigning for scheduler leadership", "instance", instanceID)
 
-		output, err := ExecuteCommand(job.Command)
+	startQueueReaper(db)
+	watchReplaceRequests(db)
+	startLogCleanup(db)
+}
 
-		executionStatus := "succeeded"
-		if err != nil {
-			executionStatus = "failed"
-			logger.L.Error("Job execution failed", "job_id", job.ID, "error", err, "output", output)
-		} else {
-			logger.L.Info("Job execution succeeded", "job_id", job.ID, "output", output)
+// JobTimeout returns how long a run of the job may take, or zero for no limit.
+// A timeout set in the job's spec takes precedence over TimeoutSeconds.
+func JobTimeout(job models.Job) time.Duration {
+	if executor, err := executorFor(job); err == nil {
+		if setter, ok := executor.(timeoutSetter); ok {
+			if timeout, ok := setter.Timeout(job); ok {
+				return timeout
+			}
 		}
+	}
+	if job.TimeoutSeconds > 0 {
+		return time.Duration(job.TimeoutSeconds) * time.Second
+	}
+	return defaultJobTimeout
+}
 
-		db.Model(&job).Update("status", executionStatus)
+// RunJob executes one attempt of a run and records it as a JobExecution. The
+// execution is saved as running before the command starts so that it can be
+// cancelled through CancelExecution while in flight. A failed attempt is
+// queued again according to the job's RetryPolicy, and the job's LastStatus
+// only reports the run's outcome once no attempts are left. Overlapping runs
+// of the same job are handled by its ConcurrencyPolicy.
+func RunJob(db *gorm.DB, run Run) models.JobExecution {
+	base, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	r, execution, ok := beginRun(db, run, cancel)
+	if !ok {
+		return execution
+	}
 
-		// Create the detailed execution record
-		executionRecord := models.JobExecution{
-			JobID:      job.ID,
-			Status:     executionStatus,
-			Output:     output,
-			FinishedAt: time.Now(),
-		}
-		if result := db.Create(&executionRecord); result.Error != nil {
-			logger.L.Error("Failed to save job execution history", "job_id", job.ID, "error", result.Error)
-		}
+	ctx := base
+	if timeout := JobTimeout(r.job); timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(base, timeout)
+		defer cancelTimeout()
+	}
+
+	err := r.setupErr
+	if err != nil {
+		// The run could not be started; say why in its output.
+		fmt.Fprintln(r.stderr, err)
+	} else {
+		var result string
+		result, err = r.executor.Execute(ctx, &Attempt{Job: r.job, Execution: &r.execution, Secrets: r.secrets, Stdout: r.stdout, Stderr: r.stderr, started: r.tracked.setPID})
+		io.WriteString(r.stdout, result)
 	}
+
+	outcome := attemptOutcome{status: models.ExecutionStatusSucceeded, err: err, exitCode: exitCode(err)}
+	switch {
+	case errors.Is(context.Cause(base), errCancelled):
+		outcome.status = models.ExecutionStatusCancelled
+		outcome.err = errCancelled
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		outcome.status = models.ExecutionStatusTimedOut
+		outcome.err = fmt.Errorf("timed out after %s", JobTimeout(r.job))
+	case err != nil:
+		outcome.status = models.ExecutionStatusFailed
+	}
+	return r.finish(db, outcome)
+}
+
+// activeRun is an attempt that has been admitted and recorded as running,
+// whether it executes in this process or on an agent.
+type activeRun struct {
+	run       Run
+	job       models.Job // with its command rendered
+	execution models.JobExecution
+	tracked   *runningExecution
+	output    *executionOutput
+	broadcast *logBroadcast
+	redactor  *secrets.Redactor
+	stdout    io.WriteCloser
+	stderr    io.WriteCloser
+	secrets   map[string]string
+	executor  Executor
+	// setupErr is why the attempt cannot be executed, if it cannot.
+	setupErr error
+}
+
+// attemptOutcome is how an attempt ended.
+type attemptOutcome struct {
+	status   string // one of the models.ExecutionStatus constants
+	err      error  // nil if the attempt succeeded
+	exitCode int    // of a command that exited, or -1
 }
 
-func ExecuteCommand(command string) (string, error) {
-	if strings.HasPrefix(command, "http") {
-		return fmt.Sprintf("Simulated HTTP GET to %s", command), nil
+// beginRun admits an attempt under the job's concurrency policy and records
+// it as running, with cancel as the way to stop it. It returns false, and
+// the execution as it is recorded, if the attempt is not to be executed
+// because its queued execution was cancelled or the policy skipped it, or
+// not yet because the policy put it back in the queue to wait.
+func beginRun(db *gorm.DB, run Run, cancel context.CancelCauseFunc) (*activeRun, models.JobExecution, bool) {
+	job := run.Job
+	if run.Attempt < 1 {
+		run.Attempt = 1
 	}
+	queued, ok := stillQueued(db, run)
+	if !ok {
+		return nil, dropRun(run, queued), false
+	}
+
+	decision, wait := admit(db, job)
+	switch {
+	case decision == models.ConcurrencyDecisionSkipped:
+		return nil, skipRun(db, run), false
+	case wait:
+		return nil, waitForTurn(db, run, queued, decision), false
+	case decision == models.ConcurrencyDecisionStarted && queued.Concurrency != "":
+		// It waited for the runs before it.
+		decision = queued.Concurrency
+	}
+
+	r := &activeRun{run: run, job: job, execution: newExecution(run, models.ExecutionStatusRunning, decision)}
+	// The execution may have been cancelled while the run was admitted, and
+	// another instance may have started a run of the job in the meantime.
+	if !saveExecution(db, run, &r.execution) {
+		release(job.ID)
+		if queued, ok := stillQueued(db, run); !ok {
+			return nil, dropRun(run, queued), false
+		}
+		return beginRun(db, run, cancel)
+	}
+	// Record the run on the job without touching its scheduling state.
+	db.Model(&job).Updates(map[string]interface{}{"last_status": models.ExecutionStatusRunning, "last_run_at": time.Now()})
+	r.tracked = track(r.execution.ID, job.ID, cancel)
 
-	cmd := exec.Command("sh", "-c", command)
+	log, err := createLogFile(r.execution.ID)
+	if err != nil {
+		logger.L.Error("Failed to create execution log", "execution_id", r.execution.ID, "error", err)
+	} else {
+		// Recorded now so that the log can be read while the run lasts.
+		r.execution.LogFile = log.path
+		db.Model(&r.execution).Update("log_file", log.path)
+	}
+	r.broadcast = startBroadcast(r.execution.ID)
+	r.output = newExecutionOutput(log, r.broadcast)
 
-	// 1. Get the current user's home directory dynamically
-	homeDir, err := os.UserHomeDir()
+	r.secrets, err = secrets.Resolve(db, job.UserID, job.Secrets)
+	// Secret values must not reach the database or the logs.
+	r.redactor = secrets.NewRedactor(r.secrets)
+	r.stdout = r.redactor.Writer(r.output.Stdout())
+	r.stderr = r.redactor.Writer(r.output.Stderr())
 	if err == nil {
-		// 2. Safely build the path: e.g., /home/username/.local/bin
-		localBin := filepath.Join(homeDir, ".local", "bin")
+		r.executor, err = executorFor(job)
+	}
+	if err == nil && isTemplated(job.Command) {
+		r.job.Command, err = RenderCommand(job, run.Params, r.execution.StartedAt)
+		r.execution.Command = r.job.Command
+	}
+	r.setupErr = err
+	return r, r.execution, true
+}
+
+// finish records how the attempt ended, queues the next attempt if the
+// job's retry policy asks for one, and releases the attempt.
+func (r *activeRun) finish(db *gorm.DB, outcome attemptOutcome) models.JobExecution {
+	job := r.job
+	execution := &r.execution
+	defer release(job.ID)
+	defer untrack(execution.ID)
 
-		// 3. Prepend it to the existing system PATH
-		customPath := fmt.Sprintf("PATH=%s:%s", localBin, os.Getenv("PATH"))
+	r.stdout.Close()
+	r.stderr.Close()
+	if err := r.output.Close(); err != nil {
+		logger.L.Error("Failed to write execution log", "execution_id", execution.ID, "error", err)
+	}
+	execution.Response = r.redactor.Redact(execution.Response)
+
+	execution.Status = outcome.status
+	if outcome.status == models.ExecutionStatusCancelled {
+		execution.CancelledBy = r.tracked.CancelledBy()
+	}
 
-		// 4. Inject the new environment variables into the command
-		cmd.Env = append(os.Environ(), customPath)
+	if outcome.err != nil {
+		logger.L.Error("Job execution failed", "job_id", job.ID, "execution_id", execution.ID, "status", execution.Status, "error", r.redactor.Redact(outcome.err.Error()), "output", r.output.combined.String())
 	} else {
-		// Fallback: If for some reason the OS doesn't know the home dir,
-		// just run it with the default environment variables.
-		fmt.Println("Warning: Could not determine home directory")
+		logger.L.Info("Job execution succeeded", "job_id", job.ID, "execution_id", execution.ID, "output", r.output.combined.String())
 	}
 
-	output, err := cmd.CombinedOutput()
-	return string(output), err
-}
+	execution.Output = r.output.combined.String()
+	execution.Stdout = r.output.stdout.String()
+	execution.Stderr = r.output.stderr.String()
+	execution.OutputBytes = r.output.combined.total
+	execution.OutputTruncated = r.output.combined.Truncated()
+	execution.FinishedAt = time.Now()
+	execution.DurationMs = execution.FinishedAt.Sub(execution.StartedAt).Milliseconds()
+	if result := db.Save(execution); result.Error != nil {
+		logger.L.Error("Failed to save job execution history", "job_id", job.ID, "error", result.Error)
+	}
+	// Subscribers look up the final status once their stream ends.
+	finishBroadcast(execution.ID, r.broadcast)
+	notifyFinished(execution.ID)
 
-func schedulerTicker(db *gorm.DB) {
-	ticker := time.NewTicker(1 * time.Second)
-	defer ticker.Stop()
+	if retryable(execution.Status, outcome.err) && r.run.Attempt < job.Retry.MaxAttempts && job.Retry.Retries(outcome.exitCode) {
+		delay := job.Retry.Delay(r.run.Attempt)
+		logger.L.Warn("Job attempt failed. Will retry.", "job_id", job.ID, "run_id", execution.RunID, "attempt", r.run.Attempt, "max_attempts", job.Retry.MaxAttempts, "delay", delay)
+		db.Model(&job).Update("last_status", models.LastStatusRetrying)
+		retryLater(db, Run{Job: r.run.Job, RunID: execution.RunID, Attempt: r.run.Attempt + 1, Trigger: models.TriggerRetry, Params: r.run.Params}, delay)
+		return *execution
+	}
 
-	for t := range ticker.C {
+	db.Model(&job).Update("last_status", execution.Status)
+	return *execution
+}
 
-		var pendingJobs []models.Job
-		db.Where("status = ?", "pending").Find(&pendingJobs)
+// newExecution returns the record of an attempt of run that starts now.
+func newExecution(run Run, status, decision string) models.JobExecution {
+	trigger := run.Trigger
+	if trigger == "" {
+		trigger = models.TriggerSchedule
+	}
+	execution := models.JobExecution{
+		JobID:       run.Job.ID,
+		Status:      status,
+		StartedAt:   time.Now(),
+		RunID:       run.RunID,
+		Attempt:     run.Attempt,
+		Concurrency: decision,
+		Trigger:     trigger,
+		Params:      run.Params,
+		Queue:       queueFor(run.Job).name,
+		WorkerID:    run.WorkerID,
+		Hostname:    hostname,
+		Instance:    instanceID,
+	}
+	if run.Agent != nil {
+		execution.Agent = run.Agent.Name
+		execution.Hostname = run.Agent.Hostname
+	}
+	return execution
+}
 
-		if len(pendingJobs) == 0 {
+func (q *workerQueue) worker(id int, db *gorm.DB) {
+	holder := fmt.Sprintf("%s/%s/%d", instanceID, q.name, id)
+	for {
+		item, ok := lease(db, []string{q.name}, holder, workerLabels)
+		if !ok {
+			waitForWork()
 			continue
 		}
-		logger.L.Info("Found pending jobs", "count", len(pendingJobs))
-
-		for _, job := range pendingJobs {
-			if scheduler.IsDue(job, t) {
-				select {
-				case JobQueue <- job:
-					logger.L.Info("Job queued for execution", "job_id", job.ID)
-				default:
-					logger.L.Warn("Job queue is full. Cannot queue job.", "job_id", job.ID)
-				}
-			}
+		stop := keepLeased(db, item)
+		if run, ok := runFor(db, item); ok {
+			logger.L.Info("Worker picked up a job", "queue", q.name, "worker_id", id, "job_id", run.Job.ID, "execution_id", run.ExecutionID, "attempt", run.Attempt)
+			run.WorkerID = id
+			RunJob(db, run)
 		}
+		stop()
+		completeItem(db, item)
 	}
 }